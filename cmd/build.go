@@ -17,19 +17,77 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// The executable and the shared libraries copied into the runtime stage are collected here
+const buildDir = ".rhino/build"
+
+// The name of the executable built by the Makefile, and its path in the function image
+const (
+	funcExecName          = "mpi-func"
+	defaultFuncExecutable = "/app/" + funcExecName
+)
+
+// The shared libraries are bundled into this directory of the function image
+const bundledLibraryDir = "/usr/local/lib"
+
+// 'rhino build --local' puts the executable and its labels here, for 'rhino local-run'
+const (
+	localBuildDir    = ".rhino/local"
+	localLabelsFile  = "labels.json"
+	localFuncVersion = "local"
+)
+
 type BuildOptions struct {
-	image string
-	file  string
+	image        string
+	file         string
+	builderImage string
+	runtimeImage string
+	mpi          string
+	buildSystem  string
+	local        bool
+	// the name of the executable built
+	executable string
+
+	// variants of the manifest built by --matrix, each one into its own output directory
+	matrix    bool
+	jobs      int
+	variant   string
+	outputDir string
+	// the output of the build, stdout if nil
+	log io.Writer
+
+	// the console output, the log file and the durations of the stages
+	quiet    bool
+	progress string
+	// --progress is only passed to docker if given, the legacy builder does not support it
+	progressChanged bool
+	logger          *buildLogger
+	stages          []buildStage
+
+	// BuildKit secrets, SSH agent forwarding and extra build args
+	secrets   []string
+	ssh       []string
+	buildArgs []string
+
+	// smoke test of the built image
+	test            bool
+	testParallel    int
+	testTimeout     time.Duration
+	removeOnFailure bool
 }
 
 func NewBuildCommand() *cobra.Command {
@@ -38,26 +96,110 @@ func NewBuildCommand() *cobra.Command {
 	buildCmd := &cobra.Command{
 		Use:   "build",
 		Short: "Build MPI function/project",
-		Long:  "\nBuild MPI function/project into a docker image",
+		Long: "\nBuild MPI function/project into a docker image" +
+			"\nThe full output is written to " + buildLogDir + "/build-<timestamp>.log, and the build ends with a summary of the time of each stage, the size and the digest of the image." +
+			"\nPrivate sources can be fetched with BuildKit secrets (--secret) and SSH forwarding (--ssh), which are only mounted into the build stage and never stored in the image." +
+			" The secrets are available at /run/secrets/<id>. --build-arg sets other build args of the Dockerfile, and the HTTP_PROXY, HTTPS_PROXY and NO_PROXY settings of the environment are passed to the build.",
 		Example: `  rhino build --image foo/hello:v1.0
-  rhino build -f ./src/config/Makefile -i bar/mpibench:v2.1 -- make -j all arch=Linux`,
+  rhino build -f ./src/config/Makefile -i bar/mpibench:v2.1 -- make -j all arch=Linux
+  rhino build -i foo/hello:v1.0 --mpi mpich --builder-image foo/mpich-builder:v1 --runtime-image foo/mpich-run:v1
+  rhino build -i foo/matmul:v2.1 --test --np 4 -- arg1 arg2
+  rhino build -i foo/matmul:v2.1 --test --np 4 -- make -j all -- arg1 arg2
+  rhino build -i foo/lulesh:v1.0 --build-system cmake -- cmake -DWITH_OPENMP=ON
+  rhino build --local -- make -j4
+  rhino build -i foo/matmul:v2.1 --matrix --jobs 4
+  rhino build -i foo/hello:v1.0 --secret id=netrc,src=$HOME/.netrc --ssh default --build-arg VERSION=1.2
+  rhino build -i foo/hello:v1.0 --quiet --progress json`,
 		Args: buildOpts.validateArgs,
 		RunE: buildOpts.runBuild,
 	}
 
 	buildCmd.Flags().StringVarP(&buildOpts.image, "image", "i", "", "full image form: [registry]/[namespace]/[name]:[tag]")
-	buildCmd.Flags().StringVarP(&buildOpts.file, "file", "f", "", "relative path of the makefile")
+	buildCmd.Flags().StringVarP(&buildOpts.file, "file", "f", "", "relative path of the makefile, or of the build file of the build system")
+	buildCmd.Flags().StringVar(&buildOpts.buildSystem, "build-system", "", "the build system, choose from [make, cmake, meson, autotools, script], detected from the files in "+defaultSourceDir+" by default")
+	buildCmd.Flags().StringVar(&buildOpts.builderImage, "builder-image", "", "base image of the build stage, default "+defaultBuilderImage)
+	buildCmd.Flags().StringVar(&buildOpts.runtimeImage, "runtime-image", "", "base image of the runtime stage, default "+defaultRuntimeImage)
+	buildCmd.Flags().StringVar(&buildOpts.mpi, "mpi", MPIOpenMPI, "the MPI implementation in the base images, choose from [openmpi, mpich]")
+	buildCmd.Flags().BoolVar(&buildOpts.local, "local", false, "build with the MPI installed on this machine, without docker")
+	buildCmd.Flags().BoolVar(&buildOpts.matrix, "matrix", false, "build every variant of the matrix in "+manifestFileName+" into its own image")
+	buildCmd.Flags().IntVar(&buildOpts.jobs, "jobs", 2, "the number of variants built at the same time with --matrix")
+	buildCmd.Flags().BoolVarP(&buildOpts.quiet, "quiet", "q", false, "only print the summary, the full output is still written to the log in "+buildLogDir)
+	buildCmd.Flags().StringVar(&buildOpts.progress, "progress", ProgressPlain, "the progress output, choose from [plain, tty, json]")
+	buildCmd.Flags().StringArrayVar(&buildOpts.secrets, "secret", nil, "secret mounted at /run/secrets/<id> in the build stage, never stored in the image: id=<id>,src=<path>")
+	buildCmd.Flags().StringArrayVar(&buildOpts.ssh, "ssh", nil, "SSH agent socket or keys forwarded to the build stage: default or <id>=<socket>|<key>")
+	buildCmd.Flags().StringArrayVar(&buildOpts.buildArgs, "build-arg", nil, "extra build arg of the Dockerfile: <name>=<value>")
+	buildCmd.Flags().BoolVar(&buildOpts.test, "test", false, "run the built image locally and fail the build if the program fails")
+	buildCmd.Flags().IntVar(&buildOpts.testParallel, "np", 1, "the number of MPI processes of the test run")
+	buildCmd.Flags().DurationVar(&buildOpts.testTimeout, "test-timeout", time.Minute, "the maximum duration of the test run")
+	buildCmd.Flags().BoolVar(&buildOpts.removeOnFailure, "remove-on-failure", false, "remove the image tag if the test run fails")
 
 	return buildCmd
 }
 
 func (b *BuildOptions) validateArgs(buildCmd *cobra.Command, args []string) error {
+	if err := b.applyManifest(buildCmd); err != nil {
+		return err
+	}
+	if len(b.buildSystem) == 0 {
+		b.buildSystem = detectBuildSystem(b.file, defaultSourceDir)
+	} else if err := validateBuildSystem(b.buildSystem); err != nil {
+		return err
+	}
+	tool := buildTool(b.buildSystem, b.buildFilePath())
+	if len(b.progress) == 0 {
+		b.progress = ProgressPlain
+	}
+	if err := validateProgress(b.progress); err != nil {
+		return err
+	}
+	if b.progress == ProgressTTY && (b.quiet || b.matrix || b.local) {
+		return fmt.Errorf("--progress tty cannot be used with --quiet, --matrix or --local")
+	}
+	b.progressChanged = buildCmd.Flags().Changed("progress")
+	if b.matrix {
+		if b.local || b.test {
+			return fmt.Errorf("--matrix cannot be used with --local or --test")
+		}
+		// Each variant writes its output to its own log file, see buildMatrix
+		if b.quiet || b.progress == ProgressJSON {
+			return fmt.Errorf("--quiet and --progress json cannot be used with --matrix")
+		}
+		if b.jobs < 1 {
+			return fmt.Errorf("the number of concurrent builds (--jobs) must be greater than 0")
+		}
+	}
+	if err := b.validateBuildKitOptions(); err != nil {
+		return err
+	}
+	if b.local {
+		if b.test {
+			return fmt.Errorf("--test cannot be used with --local, please use 'rhino local-run' instead")
+		}
+		if len(b.secrets) > 0 || len(b.ssh) > 0 || len(b.buildArgs) > 0 {
+			return fmt.Errorf("--secret, --ssh and --build-arg cannot be used with --local")
+		}
+		if len(args) > 0 && args[0] != tool {
+			return fmt.Errorf("build command must start with '%s'", tool)
+		}
+		return validateMPIFlavor(b.mpi)
+	}
 	if len(b.image) == 0 {
 		return fmt.Errorf("please provide the image name")
 	} else if len(b.image) > 63 {
 		return fmt.Errorf("the image name cannot exceed 63 characters")
-	} else if len(args) > 0 && args[0] != "make" {
-		return fmt.Errorf("build command must start with 'make'")
+	}
+	if buildCommand, _ := splitBuildArgs(args, b.test, tool); len(buildCommand) > 0 && buildCommand[0] != tool {
+		return fmt.Errorf("build command must start with '%s'", tool)
+	}
+	if b.testParallel < 1 {
+		return fmt.Errorf("the number of MPI processes (--np) must be greater than 0")
+	}
+	if err := validateMPIFlavor(b.mpi); err != nil {
+		return err
+	}
+	// The default base images ship OpenMPI, so other implementations need both images given explicitly
+	if b.mpi != MPIOpenMPI && (len(b.builderImage) == 0 || len(b.runtimeImage) == 0) {
+		return fmt.Errorf("please provide --builder-image and --runtime-image when using --mpi %s", b.mpi)
 	}
 
 	validName := regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
@@ -68,51 +210,537 @@ func (b *BuildOptions) validateArgs(buildCmd *cobra.Command, args []string) erro
 	return nil
 }
 
+// applyManifest takes the settings of the build section of the manifest which are not given on the command line
+func (b *BuildOptions) applyManifest(buildCmd *cobra.Command) error {
+	manifest, err := loadManifest(".")
+	if err != nil {
+		return err
+	}
+	spec := manifest.Build
+	if len(b.buildSystem) == 0 {
+		b.buildSystem = spec.BuildSystem
+	}
+	if len(b.file) == 0 {
+		b.file = spec.File
+	}
+	if len(b.builderImage) == 0 {
+		b.builderImage = spec.BuilderImage
+	}
+	if len(b.runtimeImage) == 0 {
+		b.runtimeImage = spec.RuntimeImage
+	}
+	if len(spec.MPI) > 0 && !buildCmd.Flags().Changed("mpi") {
+		b.mpi = spec.MPI
+	}
+	b.executable = funcExecName
+	if len(spec.Executable) > 0 {
+		b.executable = spec.Executable
+	}
+	return nil
+}
+
+// splitBuildArgs separates the build command from the arguments of the test run.
+// With --test, arguments not starting with the build tool, e.g. 'make', are all passed
+// to the test run, otherwise the test arguments follow a second '--'.
+func splitBuildArgs(args []string, test bool, tool string) (buildCommand []string, testArgs []string) {
+	if !test {
+		return args, nil
+	}
+	if len(args) > 0 && args[0] != tool && args[0] != "--" {
+		return nil, args
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
 func (b *BuildOptions) runBuild(buildCmd *cobra.Command, args []string) error {
-	var execArgs []string
-	var execCommand string
-	var buildCommand []string = []string{"make"}
-	var makefilePath string
-	var funcName string = "mpi-func" //TODO: Since the funcName is hard coded and used in different files, remove this line and use an external const to avoid inconsistency.
+	if b.matrix {
+		return b.buildMatrix(args)
+	}
 
-	// check Makefile
-	if len(b.file) == 0 {
-		makefilePath = "./src/Makefile"
+	// The output is kept in a log file, and the build ends with a summary
+	start := time.Now()
+	logger, err := openBuildLog(start, b.quiet, b.progress)
+	if err != nil {
+		return err
+	}
+	b.logger = logger
+	b.log = logger
+	if b.local {
+		err = b.stage("local", func() error { return b.buildLocal(args) })
 	} else {
-		makefilePath = b.file
+		buildCommand, testArgs := splitBuildArgs(args, b.test, buildTool(b.buildSystem, b.buildFilePath()))
+		err = b.build(buildCommand)
+		if err == nil && b.test {
+			err = b.stage("test", func() error { return b.runSmokeTest(testArgs) })
+		}
+	}
+	if summaryErr := logger.finish(b.newBuildSummary(time.Since(start), err)); summaryErr != nil && err == nil {
+		err = summaryErr
+	}
+	return err
+}
+
+// runSmokeTest runs the image just built in a local container
+func (b *BuildOptions) runSmokeTest(testArgs []string) error {
+	fmt.Fprintf(b.output(), "Testing %s with %d MPI processes...\n", b.image, b.testParallel)
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return err
+	}
+	runOpts := &DockerRunOptions{
+		parallel:   b.testParallel,
+		mpi:        b.mpi,
+		executable: "/app/" + b.executable,
 	}
-	_, err := os.Stat(makefilePath)
+	containerID, err := helper.createAndStartContainer(runOpts, append([]string{b.image}, testArgs...))
 	if err != nil {
 		return err
 	}
-	fmt.Println("Makefile path:", makefilePath)
+	defer helper.removeContainer(containerID)
+
+	logsDone := make(chan error, 1)
+	go func() {
+		logsDone <- helper.writeContainerLogs(containerID, true, "all", b.output(), b.output())
+	}()
+	err = helper.waitForContainerExit(containerID, b.testTimeout)
+	<-logsDone
+	if err == nil {
+		fmt.Fprintln(b.output(), "Test passed")
+		return nil
+	}
+
+	if b.removeOnFailure {
+		if rmErr := helper.removeImage(b.image); rmErr != nil {
+			fmt.Fprintln(b.output(), "Warning: failed to remove the image:", rmErr)
+		} else {
+			fmt.Fprintln(b.output(), "Image", b.image, "removed")
+		}
+	}
+	return fmt.Errorf("test of %s failed: %v", b.image, err)
+}
+
+// buildLocal runs the build system on this machine and copies the executable into localBuildDir
+func (b *BuildOptions) buildLocal(args []string) error {
+	makefilePath, buildCommand, err := b.buildCommand(args)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("mpicxx"); err != nil {
+		fmt.Fprintln(b.output(), "Warning: mpicxx not found in PATH, please make sure MPI is installed on this machine")
+	}
+	if manifest, err := loadManifest("."); err == nil && !manifest.Dependencies.empty() {
+		fmt.Fprintln(b.output(), "Warning: the dependencies in", manifestFileName, "are not installed by a local build, please install them on this machine")
+	}
+
+	steps := buildSteps(b.buildSystem, makefilePath, buildCommand[1:])
+	makeCmd := exec.Command("sh", "-c", steps)
+	makeCmd.Dir = filepath.Dir(makefilePath)
+	if err := runCommand(makeCmd, b.output()); err != nil {
+		return err
+	}
+	executable, err := findLocalExecutable(".", b.executable)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(b.output(), "Loading app", executable)
+	data, err := os.ReadFile(executable)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(localBuildDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(localBuildDir, b.executable), data, 0755); err != nil {
+		return err
+	}
+
+	// Local builds always come from the work tree, so the git state is not recorded
+	prov := b.newProvenance("", makefilePath, strings.Join(buildCommand[1:], " "))
+	prov.image = b.executable + ":" + localFuncVersion
+	prov.executable = filepath.Join(localBuildDir, b.executable)
+	prov.gitCommit = ""
+	labels, err := json.MarshalIndent(prov.labels(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(localBuildDir, localLabelsFile), labels, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintln(b.output(), "Built", prov.executable, "- run it with 'rhino local-run'")
+	return nil
+}
+
+// findLocalExecutable looks for the only executable named funcName under dir
+func findLocalExecutable(dir string, funcName string) (string, error) {
+	found := []string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && (entry.Name() == ".rhino" || entry.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if entry.Type().IsRegular() && entry.Name() == funcName {
+			if info, err := entry.Info(); err == nil && info.Mode()&0111 != 0 {
+				found = append(found, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return pickExecutable(found, funcName)
+}
+
+// buildFilePath returns the Makefile, or the build file of the other build systems
+func (b *BuildOptions) buildFilePath() string {
+	if len(b.file) == 0 {
+		return defaultBuildFile(b.buildSystem, defaultSourceDir)
+	}
+	return b.file
+}
+
+// output returns where the progress of the build is printed
+func (b *BuildOptions) output() io.Writer {
+	if b.log == nil {
+		return os.Stdout
+	}
+	return b.log
+}
+
+// outputPath returns the directory the executable, its shared libraries and its reports are collected into
+func (b *BuildOptions) outputPath() string {
+	if len(b.outputDir) == 0 {
+		return buildDir
+	}
+	return b.outputDir
+}
+
+// buildCommand checks the build file and returns it with the build command given or the default one
+func (b *BuildOptions) buildCommand(args []string) (string, []string, error) {
+	makefilePath := b.buildFilePath()
+	if _, err := os.Stat(makefilePath); err != nil {
+		return "", nil, err
+	}
+	fmt.Fprintln(b.output(), "Build system:", b.buildSystem)
+	fmt.Fprintln(b.output(), "Build file path:", makefilePath)
 
-	// add build args
+	buildCommand := []string{buildTool(b.buildSystem, makefilePath)}
 	if len(args) > 0 {
 		buildCommand = args
 	}
-	fmt.Println("Build command:", buildCommand)
+	fmt.Fprintln(b.output(), "Build command:", buildCommand)
 
-	// check build tools
-	buildFiles := []string{"Dockerfile", "ldd.sh"}
-	for _, file := range buildFiles {
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			return fmt.Errorf("build template not found. Please use 'rhino create' first")
+	// Compiling for minutes to find out that the executable has another name is no fun
+	if b.buildSystem == BuildSystemMake {
+		if err := preflightMakefile(b.output(), makefilePath, buildCommand[1:], b.executable); err != nil {
+			return "", nil, err
 		}
 	}
-	fmt.Println("Build tools found. Start building...")
+	return makefilePath, buildCommand, nil
+}
 
-	execCommand = "docker"
-	execArgs = []string{
-		"build", "-t", b.image,
-		"--rm",
+// build builds the function image with the given build command
+func (b *BuildOptions) build(args []string) error {
+	var funcName string = b.executable
+
+	makefilePath, buildCommand, err := b.buildCommand(args)
+	if err != nil {
+		return err
+	}
+	manifest, err := loadManifest(".")
+	if err != nil {
+		return err
+	}
+
+	// The Dockerfile is rendered from the template of this version of rhino, unless the project has its own one
+	dockerfile, err := os.ReadFile(projectDockerfile)
+	generatedDockerfile := ""
+	if os.IsNotExist(err) {
+		if generatedDockerfile, err = renderDockerfile(manifest, b.builderImage, b.runtimeImage, b.runMounts()); err != nil {
+			return err
+		}
+		dockerfile = []byte(generatedDockerfile)
+		fmt.Fprintln(b.output(), "Dockerfile generated from the template of rhino", RHINOCLIENTVERSION)
+	} else if err != nil {
+		return err
+	} else if missing := missingMounts(string(dockerfile), b.runMounts()); len(missing) > 0 {
+		fmt.Fprintln(b.output(), "Warning: the Dockerfile does not mount", strings.Join(missing, " "), "in the RUN instruction of the build stage")
+	}
+	// Projects created by older versions of rhino collect the shared libraries with ldd.sh
+	legacyTemplate := strings.Contains(string(dockerfile), "ldd.sh")
+	if _, err := os.Stat("ldd.sh"); legacyTemplate && os.IsNotExist(err) {
+		return fmt.Errorf("build template not found. Please use 'rhino create' first")
+	}
+	// Dockerfiles created by older versions of rhino have fixed base images, and only run make
+	if (len(b.builderImage) > 0 || len(b.runtimeImage) > 0) && !strings.Contains(string(dockerfile), "builder_image") {
+		return fmt.Errorf("the Dockerfile does not support custom base images. Please update it from a template created by 'rhino create'")
+	}
+	steps := buildSteps(b.buildSystem, makefilePath, buildCommand[1:])
+	supportsBuildSystems := strings.Contains(string(dockerfile), "build_command")
+	if b.buildSystem != BuildSystemMake && !supportsBuildSystems {
+		return fmt.Errorf("the Dockerfile does not support --build-system %s. Please update it from a template created by 'rhino create'", b.buildSystem)
+	}
+	fmt.Fprintln(b.output(), "Build tools found. Start building...")
+
+	buildArgs := []string{
 		"--build-arg", "func_name=" + funcName,
 		"--build-arg", "file=" + makefilePath,
-		"--build-arg", "make_args=" + strings.Join(buildCommand[1:], " "),
-		".",
 	}
+	if supportsBuildSystems {
+		buildArgs = append(buildArgs, "--build-arg", "build_command="+steps)
+	} else {
+		buildArgs = append(buildArgs, "--build-arg", "make_args="+strings.Join(buildCommand[1:], " "))
+	}
+	if len(b.builderImage) > 0 {
+		buildArgs = append(buildArgs, "--build-arg", "builder_image="+b.builderImage)
+	}
+	if len(b.runtimeImage) > 0 {
+		buildArgs = append(buildArgs, "--build-arg", "runtime_image="+b.runtimeImage)
+	}
+	if strings.Contains(string(dockerfile), "build_dir") {
+		buildArgs = append(buildArgs, "--build-arg", "build_dir="+filepath.ToSlash(b.outputPath()))
+	}
+	buildArgs = append(buildArgs, b.extraBuildArgs()...)
+
+	prov := b.newProvenance(string(dockerfile), makefilePath, strings.Join(buildCommand[1:], " "))
+	buildArgs = append(buildArgs, labelArgs(prov.labels())...)
+
+	// The packages of the manifest are installed by steps added to the Dockerfile, unless they are already in it
+	if generatedDockerfile == "" && !manifest.Dependencies.empty() {
+		if strings.Contains(string(dockerfile), dependenciesHeader) {
+			fmt.Fprintln(b.output(), "Warning: the Dockerfile already installs the dependencies written by 'rhino eject', the dependencies in", manifestFileName, "are not added again")
+		} else {
+			generatedDockerfile = injectDependencies(string(dockerfile), &manifest.Dependencies)
+			fmt.Fprintln(b.output(), "Dependencies of", manifestFileName, "added to the Dockerfile")
+		}
+	}
+
+	if legacyTemplate {
+		buildArgs = append(buildArgs, "--build-arg", "mpi="+b.mpi)
+		return b.stage("image", func() error {
+			return b.runDockerBuild(append(append([]string{"build", "-t", b.image, "--rm"}, buildArgs...), "."), generatedDockerfile)
+		})
+	}
+
+	// Build the function in the builder stage, then bundle it with its shared libraries into the runtime stage
+	builderImage := builderImageTag(b.image)
+	err = b.stage("builder", func() error {
+		return b.runDockerBuild(append(append([]string{"build", "-t", builderImage, "--rm", "--target", "builder"}, buildArgs...), "."), generatedDockerfile)
+	})
+	if err != nil {
+		return err
+	}
+	var report *DependencyReport
+	err = b.stage("dependencies", func() (err error) {
+		report, err = b.collectDependencies(builderImage, funcName)
+		return err
+	})
+	b.removeBuilderImage(builderImage)
+	if err != nil {
+		return err
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	sbom, err := json.Marshal(newSBOM(prov, report))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(b.outputPath(), "sbom.spdx.json"), sbom, 0644); err != nil {
+		return err
+	}
+	buildArgs = append(buildArgs,
+		"--label", libraryReportLabel+"="+string(reportJSON),
+		"--label", sbomLabel+"="+string(sbom),
+	)
+	return b.stage("runtime", func() error {
+		return b.runDockerBuild(append(append([]string{"build", "-t", b.image, "--rm"}, buildArgs...), "."), generatedDockerfile)
+	})
+}
+
+// newProvenance collects the source and base images of the function image
+func (b *BuildOptions) newProvenance(dockerfile string, makefilePath string, makeArgs string) *buildProvenance {
+	prov := &buildProvenance{
+		image:       b.image,
+		executable:  "/app/" + b.executable,
+		mpi:         b.mpi,
+		buildSystem: b.buildSystem,
+		variant:     b.variant,
+		makefile:    makefilePath,
+		makeArgs:    makeArgs,
+		gitSource:   getGitSource(),
+		created:     time.Now(),
+	}
+	// The source provenance is optional, projects are not always under version control
+	prov.gitCommit, prov.gitDirty, _ = getGitCommit()
+	prov.sourceDate = getSourceDate(prov.gitCommit)
+
+	baseImages := dockerfileBaseImages(dockerfile, map[string]string{
+		"builder_image": b.builderImage,
+		"runtime_image": b.runtimeImage,
+	})
+	if len(baseImages) > 0 {
+		prov.builderImage = baseImages[0]
+		prov.runtimeImage = baseImages[len(baseImages)-1]
+	}
+	// The digest is only known if the runtime image has been pulled before
+	if helper, err := NewDockerHelper(); err == nil {
+		if inspect, _, err := helper.cli.ImageInspectWithRaw(helper.ctx, prov.runtimeImage); err == nil && len(inspect.RepoDigests) > 0 {
+			prov.runtimeImageDigest = inspect.RepoDigests[0][strings.LastIndex(inspect.RepoDigests[0], "@")+1:]
+		}
+	}
+	return prov
+}
+
+// dockerfileBaseImages returns the images in the FROM instructions of the Dockerfile,
+// with the global ARGs replaced by the build args given or their default values
+func dockerfileBaseImages(dockerfile string, buildArgs map[string]string) []string {
+	args := map[string]string{}
+	images := []string{}
+	for _, line := range strings.Split(dockerfile, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if len(images) > 0 {
+				continue
+			}
+			nameValue := strings.SplitN(fields[1], "=", 2)
+			if value := buildArgs[nameValue[0]]; value != "" {
+				args[nameValue[0]] = value
+			} else if len(nameValue) == 2 {
+				args[nameValue[0]] = nameValue[1]
+			}
+		case "FROM":
+			images = append(images, os.Expand(fields[1], func(name string) string { return args[name] }))
+		}
+	}
+	return images
+}
+
+// collectDependencies copies the executable and the shared libraries it needs from builderImage into the output directory
+func (b *BuildOptions) collectDependencies(builderImage string, funcName string) (*DependencyReport, error) {
+	manifest, err := loadManifest(".")
+	if err != nil {
+		return nil, err
+	}
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return nil, err
+	}
+	containerID, err := helper.createContainer(builderImage)
+	if err != nil {
+		return nil, err
+	}
+	defer helper.removeContainer(containerID)
+
+	archive, err := helper.copyFromContainer(containerID, "/app")
+	if err != nil {
+		return nil, err
+	}
+	executable, err := findExecutable(archive, "/app", funcName)
+	archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(b.output(), "Loading app", executable)
+
+	fs := &containerFS{helper: helper, containerID: containerID}
+	if len(manifest.Dependencies.Spack) > 0 {
+		manifest.Libraries.SearchPaths = append(manifest.Libraries.SearchPaths, spackViewDir+"/lib", spackViewDir+"/lib64")
+	}
+	analyzer := newDependencyAnalyzer(fs, manifest, b.mpi)
+	report, err := analyzer.analyze(executable)
+	if err != nil {
+		return nil, err
+	}
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(b.output(), "Shared library analysis:")
+	fmt.Fprintln(b.output(), string(reportJSON))
+
+	execData, err := fs.readFile(executable)
+	if err != nil {
+		return nil, err
+	}
+	if err := analyzer.writeBundle(filepath.Join(b.outputPath(), "shared_lib")); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(b.outputPath(), funcName), execData, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(b.outputPath(), "libraries.json"), reportJSON, 0644); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// builderImageTag names the image of the build stage after the function image, e.g. foo/bar:v1 -> foo/bar:v1-builder
+func builderImageTag(image string) string {
+	if !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		image += ":latest"
+	}
+	return image + "-builder"
+}
+
+// removeBuilderImage removes the tag of the build stage once the dependencies are collected, its layers stay in the build cache
+func (b *BuildOptions) removeBuilderImage(builderImage string) {
+	helper, err := NewDockerHelper()
+	if err == nil {
+		err = helper.removeImage(builderImage)
+	}
+	if err != nil {
+		fmt.Fprintln(b.output(), "Warning: failed to remove the image of the build stage:", err)
+	}
+}
+
+func (b *BuildOptions) runDockerBuild(execArgs []string, dockerfile string) error {
+	// The context is the last argument
+	context := execArgs[len(execArgs)-1]
+	execArgs = append([]string{}, execArgs[:len(execArgs)-1]...)
+	if b.progressChanged || b.progress == ProgressJSON {
+		progress := b.progress
+		if progress == ProgressJSON {
+			// Every line of the output becomes a JSON event
+			progress = ProgressPlain
+		}
+		execArgs = append(execArgs, "--progress", progress)
+	}
+	if dockerfile != "" {
+		// The generated Dockerfile is read from stdin
+		execArgs = append(execArgs, "-f", "-")
+	}
+	cmd := exec.Command("docker", append(execArgs, context)...)
+	if dockerfile != "" {
+		cmd.Stdin = strings.NewReader(dockerfile)
+	}
+	if b.useBuildKit() || b.progressChanged || b.progress == ProgressJSON {
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	}
+	if b.progress == ProgressTTY {
+		// The interactive display of BuildKit needs the terminal, so its output is not in the log
+		fmt.Fprintln(b.output(), "The output of docker build is shown on the terminal with --progress tty")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return runCommand(cmd, b.output())
+}
 
-	cmd := exec.Command(execCommand, execArgs...)
+// runCommand runs a command and prints its output to out
+func runCommand(cmd *exec.Cmd, out io.Writer) error {
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -127,8 +755,12 @@ func (b *BuildOptions) runBuild(buildCmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	go printPipeOutput(stdoutPipe)
-	go printPipeOutput(stderrPipe)
+	// All the output must be read before waiting for the command
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go printPipeOutput(stdoutPipe, out, &wg)
+	go printPipeOutput(stderrPipe, out, &wg)
+	wg.Wait()
 
 	err = cmd.Wait()
 	if err != nil {
@@ -137,11 +769,12 @@ func (b *BuildOptions) runBuild(buildCmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func printPipeOutput(pipe io.ReadCloser) {
+func printPipeOutput(pipe io.ReadCloser, out io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
 	scanner := bufio.NewScanner(pipe)
 	scanner.Split(bufio.ScanLines)
 	for scanner.Scan() {
 		output := scanner.Text()
-		fmt.Println(output)
+		fmt.Fprintln(out, output)
 	}
 }
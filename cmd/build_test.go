@@ -18,8 +18,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -83,3 +85,192 @@ func TestBuildSingleFileCpp(t *testing.T) {
 	os.RemoveAll(testFuncName)
 
 }
+
+// check if the errors are reported when the MPI implementation or base images are set incorrectly
+func TestBuildMPIFlavorErr(t *testing.T) {
+	rootCmd := NewRootCommand()
+	rootCmd.SetArgs([]string{"build", "--image", "test-build-mpi:v1", "--mpi", "mvapich"})
+	err := rootCmd.Execute()
+	assert.Equal(t, fmt.Errorf("the MPI implementation (--mpi) must be either openmpi or mpich"), err, "test failed: invalid MPI implementation not reported")
+
+	rootCmd = NewRootCommand()
+	rootCmd.SetArgs([]string{"build", "--image", "test-build-mpi:v1", "--mpi", "mpich", "--builder-image", "foo/mpich-builder:v1"})
+	err = rootCmd.Execute()
+	assert.Equal(t, fmt.Errorf("please provide --builder-image and --runtime-image when using --mpi mpich"), err, "test failed: missing runtime image not reported")
+}
+
+func TestDockerfileBaseImages(t *testing.T) {
+	dockerfile := "ARG builder_image=foo/builder:v1\nARG runtime_image=foo/run:v1\n\n" +
+		"FROM ${builder_image} as builder\nARG file\nRUN make\n\nFROM ${runtime_image}\nCMD [\"/bin/sh\"]"
+	images := dockerfileBaseImages(dockerfile, map[string]string{"runtime_image": "bar/run:v2"})
+	assert.Equal(t, []string{"foo/builder:v1", "bar/run:v2"}, images)
+
+	// Dockerfiles created by older versions have fixed base images
+	images = dockerfileBaseImages("FROM openrhino/mpibuilder_base:v0.1.0 as builder\nFROM openrhino/mpirun_base:v0.1.0", nil)
+	assert.Equal(t, []string{defaultBuilderImage, defaultRuntimeImage}, images)
+}
+
+func TestSplitBuildArgs(t *testing.T) {
+	buildCommand, testArgs := splitBuildArgs([]string{"make", "-j4"}, false, "make")
+	assert.Equal(t, []string{"make", "-j4"}, buildCommand)
+	assert.Equal(t, []string(nil), testArgs)
+
+	// with --test, arguments not starting with make are passed to the test run
+	buildCommand, testArgs = splitBuildArgs([]string{"1", "2"}, true, "make")
+	assert.Equal(t, []string(nil), buildCommand)
+	assert.Equal(t, []string{"1", "2"}, testArgs)
+
+	buildCommand, testArgs = splitBuildArgs([]string{"make", "-j4", "--", "1", "2"}, true, "make")
+	assert.Equal(t, []string{"make", "-j4"}, buildCommand)
+	assert.Equal(t, []string{"1", "2"}, testArgs)
+
+	buildCommand, testArgs = splitBuildArgs([]string{"--", "make"}, true, "make")
+	assert.Equal(t, []string{}, buildCommand)
+	assert.Equal(t, []string{"make"}, testArgs)
+}
+
+func TestFindLocalExecutable(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{
+		"src/main.cpp":              0644,
+		"src/mpi-func":              0755,
+		".rhino/local/mpi-func":     0755,
+		".rhino/build/mpi-func":     0755,
+		"src/mpi-func.d/not-a-func": 0644,
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte{}, mode)
+	}
+	// the copies made by earlier builds are skipped
+	executable, err := findLocalExecutable(dir, funcExecName)
+	assert.Equal(t, nil, err, "test find local executable failed: %s", errorMessage(err))
+	assert.Equal(t, filepath.Join(dir, "src", funcExecName), executable)
+
+	os.WriteFile(filepath.Join(dir, funcExecName), []byte{}, 0755)
+	_, err = findLocalExecutable(dir, funcExecName)
+	assert.Equal(t, true, err != nil && strings.HasPrefix(err.Error(), "found multiple executable files"), "test failed: duplicate executables not reported")
+
+	rootCmd := NewRootCommand()
+	rootCmd.SetArgs([]string{"build", "--local", "--test"})
+	err = rootCmd.Execute()
+	assert.Equal(t, fmt.Errorf("--test cannot be used with --local, please use 'rhino local-run' instead"), err)
+}
+
+func TestDetectBuildSystem(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, BuildSystemMake, detectBuildSystem("", dir), "test failed: make is not the default")
+	os.WriteFile(filepath.Join(dir, "Makefile"), []byte{}, 0644)
+	os.WriteFile(filepath.Join(dir, "CMakeLists.txt"), []byte{}, 0644)
+	// CMake projects may contain a Makefile
+	assert.Equal(t, BuildSystemCMake, detectBuildSystem("", dir))
+	assert.Equal(t, BuildSystemMake, detectBuildSystem("./src/conf/linux.makefile", dir))
+	assert.Equal(t, BuildSystemMeson, detectBuildSystem("src/meson.build", dir))
+	assert.Equal(t, BuildSystemScript, detectBuildSystem("src/compile.sh", dir))
+	assert.Equal(t, "configure", buildTool(BuildSystemAutotools, "src/configure.ac"))
+	assert.Equal(t, "compile.sh", buildTool(BuildSystemScript, "src/compile.sh"))
+	assert.Equal(t, fmt.Errorf("the build system (--build-system) must be one of make, cmake, meson, autotools or script"), validateBuildSystem("bazel"))
+}
+
+func TestBuildSteps(t *testing.T) {
+	assert.Equal(t, "make -B -f Makefile -j4 all", buildSteps(BuildSystemMake, "./src/Makefile", []string{"-j4", "all"}))
+	assert.Equal(t, "cmake -S . -B build -DCMAKE_BUILD_TYPE=Release '-DFLAGS=-O2 -g' && cmake --build build --parallel",
+		buildSteps(BuildSystemCMake, "./src/CMakeLists.txt", []string{"-DFLAGS=-O2 -g"}))
+	assert.Equal(t, "meson setup build --buildtype=release && meson compile -C build", buildSteps(BuildSystemMeson, "./src/meson.build", nil))
+	assert.Equal(t, "sh build.sh 'it'\\''s'", buildSteps(BuildSystemScript, "./src/build.sh", []string{"it's"}))
+}
+
+func TestMatrix(t *testing.T) {
+	assert.Equal(t, "foo/bar:v1-o3", variantImage("foo/bar:v1", "o3"))
+	assert.Equal(t, "localhost:5000/bar:latest-o3", variantImage("localhost:5000/bar", "o3"))
+
+	err := validateMatrix([]MatrixVariant{{Name: "o2"}, {Name: "o2", Args: []string{"CXXFLAGS=-O3"}}})
+	assert.Equal(t, fmt.Errorf("duplicate matrix variant o2"), err)
+	err = validateMatrix([]MatrixVariant{{Name: "o2:native"}})
+	assert.Equal(t, true, err != nil, "test failed: invalid variant name not reported")
+
+	var out strings.Builder
+	printMatrixSummary(&out, []matrixResult{
+		{variant: "o2", image: "foo/bar:v1-o2", log: ".rhino/build/o2/build.log", duration: 61 * time.Second},
+		{variant: "native", image: "foo/bar:v1-native", log: ".rhino/build/native/build.log", err: fmt.Errorf("exit status 2")},
+	})
+	expected := "VARIANT  IMAGE              STATUS  DURATION  LOG\n" +
+		"o2       foo/bar:v1-o2      ok      1m1s      .rhino/build/o2/build.log\n" +
+		"native   foo/bar:v1-native  failed  0s        .rhino/build/native/build.log\n"
+	assert.Equal(t, expected, out.String())
+}
+
+func TestBuildKitOptions(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "netrc")
+	os.WriteFile(secretFile, []byte("machine example.com"), 0600)
+	t.Setenv("HTTPS_PROXY", "http://proxy:3128")
+	t.Setenv("no_proxy", "localhost")
+
+	b := &BuildOptions{
+		secrets:   []string{"id=netrc,src=" + secretFile},
+		ssh:       []string{"default", "github=/tmp/agent.sock"},
+		buildArgs: []string{"VERSION=1.2"},
+	}
+	err := b.validateBuildKitOptions()
+	assert.Equal(t, nil, err, errorMessage(err))
+	assert.Equal(t, true, b.useBuildKit())
+	assert.Equal(t, []string{"--mount=type=secret,id=netrc", "--mount=type=ssh", "--mount=type=ssh,id=github"}, b.runMounts())
+	assert.Equal(t, []string{"--secret", "id=netrc,src=" + secretFile, "--ssh", "default", "--ssh", "github=/tmp/agent.sock",
+		"--build-arg", "VERSION=1.2", "--build-arg", "HTTPS_PROXY", "--build-arg", "no_proxy"}, b.extraBuildArgs())
+
+	_, err = parseSecretSpec("src=" + secretFile)
+	assert.Equal(t, fmt.Errorf("invalid secret src=%s, the id is missing", secretFile), err)
+	assert.Equal(t, fmt.Errorf("the build arg func_name is set by rhino build"), validateBuildArg("func_name=foo"))
+	assert.Equal(t, []string{"--mount=type=ssh"}, missingMounts("RUN --mount=type=secret,id=netrc make", b.runMounts()[:2]))
+
+	dockerfile, err := renderDockerfile(&ProjectManifest{}, "", "", b.runMounts()[:1])
+	assert.Equal(t, nil, err, errorMessage(err))
+	assert.Equal(t, true, strings.Contains(dockerfile, "RUN --mount=type=secret,id=netrc cd $(dirname ${file})"), "test failed: secret not mounted")
+}
+
+func TestBuildSummary(t *testing.T) {
+	assert.Equal(t, fmt.Errorf("the progress output (--progress) must be one of plain, tty or json"), validateProgress("auto"))
+	b := &BuildOptions{mpi: MPIOpenMPI, local: true}
+	err := b.validateArgs(NewBuildCommand(), nil)
+	assert.Equal(t, nil, err, errorMessage(err))
+	assert.Equal(t, ProgressPlain, b.progress)
+	b = &BuildOptions{image: "foo/bar:v1", mpi: MPIOpenMPI, matrix: true, jobs: 2, testParallel: 1, progress: ProgressJSON}
+	assert.Equal(t, fmt.Errorf("--quiet and --progress json cannot be used with --matrix"), b.validateArgs(NewBuildCommand(), nil))
+
+	var out strings.Builder
+	printBuildSummary(&out, &buildSummary{
+		Image:  "foo/bar:v1",
+		Digest: "sha256:0123",
+		Size:   3 << 20,
+		Status: "ok",
+		Stages: []buildStage{
+			{Name: "builder", Duration: 72 * time.Second, Status: "ok"},
+			{Name: "dependencies", Duration: 1500 * time.Millisecond, Status: "ok"},
+		},
+		Log:     ".rhino/logs/build-20231019-101500.log",
+		elapsed: 74 * time.Second,
+	})
+	expected := "Build summary:\n" +
+		"  Status:              ok\n" +
+		"  Image:               foo/bar:v1\n" +
+		"  Digest:              sha256:0123\n" +
+		"  Size:                3.0MiB\n" +
+		"  Stage builder:       1m12s\n" +
+		"  Stage dependencies:  1.5s\n" +
+		"  Total time:          1m14s\n" +
+		"  Log:                 .rhino/logs/build-20231019-101500.log\n"
+	assert.Equal(t, expected, out.String())
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "build.log"))
+	assert.Equal(t, nil, err, errorMessage(err))
+	var console strings.Builder
+	logger := &buildLogger{file: file, path: file.Name(), console: &console, json: true}
+	logger.startStage("builder")
+	fmt.Fprint(logger, "Step 1/9 : FROM ")
+	fmt.Fprintln(logger, "openrhino/mpi-builder")
+	logger.endStage(buildStage{Name: "builder", Seconds: 1.5, Status: "ok"})
+	assert.Equal(t, `{"message":"Step 1/9 : FROM openrhino/mpi-builder","stage":"builder","type":"log"}`+"\n"+
+		`{"seconds":1.5,"stage":"builder","status":"ok","type":"stage"}`+"\n", console.String())
+	file.Close()
+	log, _ := os.ReadFile(file.Name())
+	assert.Equal(t, "==> builder\nStep 1/9 : FROM openrhino/mpi-builder\n", string(log))
+}
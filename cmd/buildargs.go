@@ -0,0 +1,159 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The build args set by 'rhino build' itself
+var reservedBuildArgs = []string{"func_name", "file", "build_command", "make_args", "builder_image", "runtime_image", "build_dir", "mpi"}
+
+// The proxy settings passed from the environment to the build. Docker does not keep them in the image history.
+var proxyVariables = []string{"HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "NO_PROXY", "ALL_PROXY"}
+
+// parseSecretSpec checks a --secret option, e.g. id=npmrc,src=$HOME/.npmrc, and returns the id of the secret
+func parseSecretSpec(spec string) (string, error) {
+	id, src := "", ""
+	for _, field := range strings.Split(spec, ",") {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return "", fmt.Errorf("invalid secret %s, the format is id=<id>,src=<path> or id=<id>,env=<variable>", spec)
+		}
+		switch keyValue[0] {
+		case "id":
+			id = keyValue[1]
+		case "src", "source":
+			src = keyValue[1]
+		case "env":
+			if _, ok := os.LookupEnv(keyValue[1]); !ok {
+				return "", fmt.Errorf("the environment variable %s of the secret %s is not set", keyValue[1], spec)
+			}
+		case "type":
+		default:
+			return "", fmt.Errorf("invalid secret %s, unknown field %s", spec, keyValue[0])
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("invalid secret %s, the id is missing", spec)
+	}
+	if src != "" {
+		if _, err := os.Stat(src); err != nil {
+			return "", fmt.Errorf("cannot read the secret %s: %v", id, err)
+		}
+	}
+	return id, nil
+}
+
+// parseSSHSpec checks a --ssh option, e.g. default or github=$HOME/.ssh/id_rsa, and returns its id
+func parseSSHSpec(spec string) (string, error) {
+	id := strings.SplitN(spec, "=", 2)[0]
+	if id == "" {
+		return "", fmt.Errorf("invalid ssh %s, the format is default or <id>[=<socket>|<key>[,<key>]]", spec)
+	}
+	return id, nil
+}
+
+func validateBuildArg(arg string) error {
+	name := strings.SplitN(arg, "=", 2)[0]
+	if name == "" {
+		return fmt.Errorf("invalid build arg %s, the format is <name>=<value>", arg)
+	}
+	for _, reserved := range reservedBuildArgs {
+		if name == reserved {
+			return fmt.Errorf("the build arg %s is set by rhino build", name)
+		}
+	}
+	return nil
+}
+
+// validateBuildKitOptions checks --secret, --ssh and --build-arg
+func (b *BuildOptions) validateBuildKitOptions() error {
+	for _, spec := range b.secrets {
+		if _, err := parseSecretSpec(spec); err != nil {
+			return err
+		}
+	}
+	for _, spec := range b.ssh {
+		if _, err := parseSSHSpec(spec); err != nil {
+			return err
+		}
+	}
+	for _, arg := range b.buildArgs {
+		if err := validateBuildArg(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// useBuildKit tells whether the build needs BuildKit, which provides the secret and SSH mounts
+func (b *BuildOptions) useBuildKit() bool {
+	return len(b.secrets) > 0 || len(b.ssh) > 0
+}
+
+// extraBuildArgs returns the 'docker build' arguments of --secret, --ssh, --build-arg and of the proxy settings
+func (b *BuildOptions) extraBuildArgs() []string {
+	args := []string{}
+	for _, spec := range b.secrets {
+		args = append(args, "--secret", spec)
+	}
+	for _, spec := range b.ssh {
+		args = append(args, "--ssh", spec)
+	}
+	for _, arg := range b.buildArgs {
+		args = append(args, "--build-arg", arg)
+	}
+	// Without a value, docker takes the one of the environment, so it does not show in the process list
+	for _, name := range proxyVariables {
+		for _, variable := range []string{name, strings.ToLower(name)} {
+			if _, ok := os.LookupEnv(variable); ok {
+				args = append(args, "--build-arg", variable)
+			}
+		}
+	}
+	return args
+}
+
+// runMounts returns the mounts of the secrets and the SSH agent for the RUN instruction of the builder stage
+func (b *BuildOptions) runMounts() []string {
+	mounts := []string{}
+	for _, spec := range b.secrets {
+		id, _ := parseSecretSpec(spec)
+		mounts = append(mounts, "--mount=type=secret,id="+id)
+	}
+	for _, spec := range b.ssh {
+		if id, _ := parseSSHSpec(spec); id == "default" {
+			mounts = append(mounts, "--mount=type=ssh")
+		} else {
+			mounts = append(mounts, "--mount=type=ssh,id="+id)
+		}
+	}
+	return mounts
+}
+
+// missingMounts returns the mounts a Dockerfile of the project does not have
+func missingMounts(dockerfile string, mounts []string) []string {
+	missing := []string{}
+	for _, mount := range mounts {
+		if !strings.Contains(dockerfile, strings.TrimPrefix(mount, "--mount=")) {
+			missing = append(missing, mount)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// The full output of every 'rhino build' is kept here
+const buildLogDir = ".rhino/logs"
+
+// The progress outputs of 'rhino build'
+const (
+	ProgressPlain = "plain"
+	ProgressTTY   = "tty"
+	ProgressJSON  = "json"
+)
+
+func validateProgress(progress string) error {
+	if progress != ProgressPlain && progress != ProgressTTY && progress != ProgressJSON {
+		return fmt.Errorf("the progress output (--progress) must be one of plain, tty or json")
+	}
+	return nil
+}
+
+// buildStage is a step of the build and the time it took
+type buildStage struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+	Status   string        `json:"status"`
+}
+
+// buildSummary is printed at the end of 'rhino build'
+type buildSummary struct {
+	Image   string       `json:"image,omitempty"`
+	Digest  string       `json:"digest,omitempty"`
+	Size    int64        `json:"size,omitempty"`
+	Status  string       `json:"status"`
+	Error   string       `json:"error,omitempty"`
+	Stages  []buildStage `json:"stages"`
+	Total   float64      `json:"seconds"`
+	Log     string       `json:"log"`
+	elapsed time.Duration
+}
+
+// buildLogger tees the output of the build to the log file and to the console
+type buildLogger struct {
+	file *os.File
+	path string
+	mu   sync.Mutex
+	// the console output, nil with --quiet
+	console io.Writer
+	json    bool
+	stage   string
+	partial []byte
+}
+
+// openBuildLog creates the log file of a build started at start
+func openBuildLog(start time.Time, quiet bool, progress string) (*buildLogger, error) {
+	if err := os.MkdirAll(buildLogDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(buildLogDir, "build-"+start.Format("20060102-150405")+".log")
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &buildLogger{file: file, path: path, json: progress == ProgressJSON}
+	if !quiet {
+		l.console = os.Stdout
+	}
+	return l, nil
+}
+
+// Write writes p to the log file, and to the console line by line, as JSON events with --progress json
+func (l *buildLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(p); err != nil {
+		return 0, err
+	}
+	if l.console == nil {
+		return len(p), nil
+	}
+	if !l.json {
+		return l.console.Write(p)
+	}
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.event(map[string]interface{}{"type": "log", "stage": l.stage, "message": string(l.partial[:i])})
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// event prints a JSON event to the console, the caller holds the lock
+func (l *buildLogger) event(event map[string]interface{}) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		fmt.Fprintln(l.console, string(data))
+	}
+}
+
+func (l *buildLogger) startStage(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stage = name
+	fmt.Fprintf(l.file, "==> %s\n", name)
+}
+
+func (l *buildLogger) endStage(stage buildStage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json && l.console != nil {
+		l.event(map[string]interface{}{"type": "stage", "stage": stage.Name, "seconds": stage.Seconds, "status": stage.Status})
+	}
+}
+
+// finish prints the summary to the log file and to the console, and closes the log file
+func (l *buildLogger) finish(summary *buildSummary) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.file.Close()
+	fmt.Fprintln(l.file)
+	if err := printBuildSummary(l.file, summary); err != nil {
+		return err
+	}
+	if l.json {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		event["type"] = "summary"
+		if l.console == nil {
+			l.console = os.Stdout
+		}
+		l.event(event)
+		return nil
+	}
+	fmt.Println()
+	return printBuildSummary(os.Stdout, summary)
+}
+
+// stage runs a step of the build and records its duration
+func (b *BuildOptions) stage(name string, step func() error) error {
+	if b.logger != nil {
+		b.logger.startStage(name)
+	}
+	start := time.Now()
+	err := step()
+	stage := buildStage{Name: name, Duration: time.Since(start), Status: "ok"}
+	stage.Seconds = stage.Duration.Round(time.Millisecond).Seconds()
+	if err != nil {
+		stage.Status = "failed"
+	}
+	b.stages = append(b.stages, stage)
+	if b.logger != nil {
+		b.logger.endStage(stage)
+	}
+	return err
+}
+
+// newBuildSummary collects the durations of the build, and the size and digest of the image if it was built
+func (b *BuildOptions) newBuildSummary(elapsed time.Duration, err error) *buildSummary {
+	summary := &buildSummary{Status: "ok", Stages: b.stages, Total: elapsed.Round(time.Millisecond).Seconds(), elapsed: elapsed}
+	if b.logger != nil {
+		summary.Log = b.logger.path
+	}
+	if err != nil {
+		summary.Status = "failed"
+		summary.Error = err.Error()
+	}
+	if b.local {
+		summary.Image = filepath.Join(localBuildDir, b.executable)
+		return summary
+	}
+	summary.Image = b.image
+	if err != nil {
+		return summary
+	}
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return summary
+	}
+	if inspect, _, err := helper.cli.ImageInspectWithRaw(helper.ctx, b.image); err == nil {
+		summary.Size = inspect.Size
+		summary.Digest = inspect.ID
+		if len(inspect.RepoDigests) > 0 {
+			summary.Digest = inspect.RepoDigests[0]
+		}
+	}
+	return summary
+}
+
+func printBuildSummary(w io.Writer, summary *buildSummary) error {
+	tw := tabwriter.NewWriter(w, 2, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Build summary:")
+	fmt.Fprintf(tw, "  Status:\t%s\n", summary.Status)
+	if summary.Image != "" {
+		fmt.Fprintf(tw, "  Image:\t%s\n", summary.Image)
+	}
+	if summary.Digest != "" {
+		fmt.Fprintf(tw, "  Digest:\t%s\n", summary.Digest)
+	}
+	if summary.Size > 0 {
+		fmt.Fprintf(tw, "  Size:\t%s\n", formatSize(summary.Size))
+	}
+	for _, stage := range summary.Stages {
+		status := ""
+		if stage.Status != "ok" {
+			status = " (" + stage.Status + ")"
+		}
+		fmt.Fprintf(tw, "  Stage %s:\t%v%s\n", stage.Name, stage.Duration.Round(100*time.Millisecond), status)
+	}
+	fmt.Fprintf(tw, "  Total time:\t%v\n", summary.elapsed.Round(100*time.Millisecond))
+	if summary.Log != "" {
+		fmt.Fprintf(tw, "  Log:\t%s\n", summary.Log)
+	}
+	if summary.Error != "" {
+		fmt.Fprintf(tw, "  Error:\t%s\n", strings.TrimSpace(summary.Error))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The build systems supported by 'rhino build'
+const (
+	BuildSystemMake      = "make"
+	BuildSystemCMake     = "cmake"
+	BuildSystemMeson     = "meson"
+	BuildSystemAutotools = "autotools"
+	BuildSystemScript    = "script"
+)
+
+// The source directory of the projects created by 'rhino create'
+const defaultSourceDir = "./src"
+
+// buildSystemFiles lists the files identifying each build system, in the order of detection.
+// CMake and autotools projects may also contain a Makefile, so they are checked first.
+var buildSystemFiles = []struct {
+	system string
+	files  []string
+}{
+	{BuildSystemCMake, []string{"CMakeLists.txt"}},
+	{BuildSystemMeson, []string{"meson.build"}},
+	{BuildSystemAutotools, []string{"configure.ac", "configure"}},
+	{BuildSystemMake, []string{"Makefile"}},
+	{BuildSystemScript, []string{"build.sh"}},
+}
+
+func validateBuildSystem(system string) error {
+	for _, s := range buildSystemFiles {
+		if s.system == system {
+			return nil
+		}
+	}
+	return fmt.Errorf("the build system (--build-system) must be one of make, cmake, meson, autotools or script")
+}
+
+// detectBuildSystem returns the build system of the build file, or of the project in dir if file is empty
+func detectBuildSystem(file string, dir string) string {
+	if file != "" {
+		name := filepath.Base(file)
+		for _, s := range buildSystemFiles {
+			for _, f := range s.files {
+				if name == f {
+					return s.system
+				}
+			}
+		}
+		if strings.HasSuffix(name, ".sh") {
+			return BuildSystemScript
+		}
+		return BuildSystemMake
+	}
+	for _, s := range buildSystemFiles {
+		for _, f := range s.files {
+			if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+				return s.system
+			}
+		}
+	}
+	return BuildSystemMake
+}
+
+// defaultBuildFile returns the build file of system in dir, the first one existing if there are several candidates
+func defaultBuildFile(system string, dir string) string {
+	for _, s := range buildSystemFiles {
+		if s.system != system {
+			continue
+		}
+		for _, f := range s.files {
+			if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+				return filepath.Join(dir, f)
+			}
+		}
+		return filepath.Join(dir, s.files[0])
+	}
+	return filepath.Join(dir, "Makefile")
+}
+
+// buildTool returns the word the build command given after '--' must start with
+func buildTool(system string, file string) string {
+	switch system {
+	case BuildSystemAutotools:
+		return "configure"
+	case BuildSystemScript:
+		return filepath.Base(file)
+	default:
+		return system
+	}
+}
+
+// buildSteps returns the shell command building the project with system, run in the directory of file.
+// The arguments given after the build tool are passed to the configure step, or to make.
+func buildSteps(system string, file string, args []string) string {
+	extra := ""
+	for _, arg := range args {
+		extra += " " + shellQuote(arg)
+	}
+	switch system {
+	case BuildSystemCMake:
+		return "cmake -S . -B build -DCMAKE_BUILD_TYPE=Release" + extra + " && cmake --build build --parallel"
+	case BuildSystemMeson:
+		return "meson setup build --buildtype=release" + extra + " && meson compile -C build"
+	case BuildSystemAutotools:
+		return "if [ ! -x configure ]; then autoreconf -fi; fi && ./configure" + extra + " && make"
+	case BuildSystemScript:
+		return "sh " + shellQuote(path.Base(filepath.ToSlash(file))) + extra
+	default:
+		return "make -B -f " + shellQuote(path.Base(filepath.ToSlash(file))) + extra
+	}
+}
+
+// shellQuote quotes s for sh if needed
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+./:,@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -17,17 +17,22 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/term"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
@@ -94,6 +99,20 @@ func getFuncName(image string) string {
 	return funcName
 }
 
+// getGitCommit returns the commit checked out in the current directory and whether the work tree has changes
+func getGitCommit() (commit string, dirty bool, err error) {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false, fmt.Errorf("not a git repository or git not installed")
+	}
+	commit = strings.TrimSpace(string(out))
+	out, err = exec.Command("git", "status", "--porcelain").Output()
+	if err != nil {
+		return "", false, err
+	}
+	return commit, len(strings.TrimSpace(string(out))) > 0, nil
+}
+
 // DockerHelper is a helper struct for Docker operations
 type DockerHelper struct {
 	ctx context.Context
@@ -114,74 +133,159 @@ func NewDockerHelper() (*DockerHelper, error) {
 	}, nil
 }
 
-func (dh *DockerHelper) checkAndPullImage(image string) error {
+// checkAndPullImage pulls image with the credentials of the docker config, always, if it is missing or never
+func (dh *DockerHelper) checkAndPullImage(image string, policy string) error {
 	_, _, err := dh.cli.ImageInspectWithRaw(dh.ctx, image)
+	if err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+	if err == nil && policy != PullAlways {
+		return nil
+	}
+	if err != nil && policy == PullNever {
+		return fmt.Errorf("image %s not found locally, and the pull policy (--pull) is never", image)
+	}
+
 	if err != nil {
-		if client.IsErrNotFound(err) {
-			fmt.Printf("Image %s not found, pulling from Docker Hub...\n", image)
-			// Pull the image
-			out, err := dh.cli.ImagePull(dh.ctx, image, types.ImagePullOptions{})
-			if err != nil {
-				return err
-			}
-			defer out.Close()
-			// Copy the output to stdout
-			_, err = io.Copy(os.Stdout, out)
-			if err != nil && err != io.EOF {
-				return err
-			}
-		} else {
-			return err
-		}
+		fmt.Printf("Image %s not found, pulling from %s...\n", image, imageRegistry(image))
+	} else {
+		fmt.Printf("Pulling %s from %s...\n", image, imageRegistry(image))
 	}
-	return nil
+	auth, err := registryAuth(image)
+	if err != nil {
+		return err
+	}
+	out, err := dh.cli.ImagePull(dh.ctx, image, types.ImagePullOptions{RegistryAuth: auth})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	fd, isTerm := term.GetFdInfo(os.Stdout)
+	return jsonmessage.DisplayJSONMessagesStream(out, os.Stdout, fd, isTerm, nil)
+}
+
+// createContainer creates a container without starting it, to access the filesystem of image
+func (dh *DockerHelper) createContainer(image string) (string, error) {
+	containerConfig := &container.Config{
+		Image:      image,
+		Entrypoint: []string{"/bin/sh"},
+	}
+	resp, err := dh.cli.ContainerCreate(dh.ctx, containerConfig, &container.HostConfig{}, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (dh *DockerHelper) removeContainer(containerID string) error {
+	return dh.cli.ContainerRemove(dh.ctx, containerID, types.ContainerRemoveOptions{Force: true})
+}
+
+// readImageFile reads a file from the filesystem of image
+func (dh *DockerHelper) readImageFile(image string, name string) ([]byte, error) {
+	containerID, err := dh.createContainer(image)
+	if err != nil {
+		return nil, err
+	}
+	defer dh.removeContainer(containerID)
+	fs := &containerFS{helper: dh, containerID: containerID}
+	realPath, err := fs.realPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.readFile(realPath)
+}
+
+// copyFromContainer returns a tar stream of srcPath in the container
+func (dh *DockerHelper) copyFromContainer(containerID string, srcPath string) (io.ReadCloser, error) {
+	reader, _, err := dh.cli.CopyFromContainer(dh.ctx, containerID, srcPath)
+	return reader, err
 }
 
 func (dh *DockerHelper) createAndStartContainer(r *DockerRunOptions, args []string) (string, error) {
+	containerConfig, hostConfig, err := r.containerConfig(args)
+	if err != nil {
+		return "", err
+	}
+	return dh.startContainer(containerConfig, hostConfig, nil, r.name)
+}
+
+// containerConfig returns the configuration of a container running the function with mpirun
+func (r *DockerRunOptions) containerConfig(args []string) (*container.Config, *container.HostConfig, error) {
 	// Configure the container
-	entrypoint := []string{"mpirun", "-np", strconv.Itoa(r.parallel), "/app/mpi-func"}
+	launcherArgs, err := r.launcherArgs()
+	if err != nil {
+		return nil, nil, err
+	}
+	mpirun := mpirunCommand(r.mpi, r.parallel, r.executable)
+	entrypoint := append(append([]string{mpirun[0]}, launcherArgs...), mpirun[1:]...)
 	containerConfig := &container.Config{
 		Image:      args[0],
 		Entrypoint: entrypoint,
 		Cmd:        args[1:],
-		Env: []string{
-			"OMPI_MCA_btl_base_warn_component_unused=0", // Suppress OpenMPI warning, if OpenMPI is used
-		},
+		Env:        mpiEnv(r.mpi),
 	}
+	containerConfig.Labels = r.runLabels(runRoleSingle)
 	hostConfig := &container.HostConfig{}
+	r.setLimits(hostConfig, r.parallel)
 
-	// Bind mount a volume if "-v" is specified
-	hostPath, containerPath := "", ""
-	if r.volume != "" {
-		volumeParts := strings.SplitN(r.volume, ":", 2)
-		if len(volumeParts) == 2 {
-			hostPath, containerPath = volumeParts[0], volumeParts[1]
-		} else {
-			return "", fmt.Errorf("invalid volume format, should be <host-path>:<container-path>")
-		}
+	binds, err := r.binds()
+	if err != nil {
+		return nil, nil, err
+	}
+	hostConfig.Binds = binds
+	if hostConfig.Tmpfs, err = r.tmpfsMounts(); err != nil {
+		return nil, nil, err
 	}
-	if hostPath != "" && containerPath != "" {
-		bind := []string{hostPath + ":" + containerPath}
-		hostConfig.Binds = bind
+	env, err := r.userEnv()
+	if err != nil {
+		return nil, nil, err
 	}
+	containerConfig.Env = append(containerConfig.Env, env...)
+	containerConfig.WorkingDir = r.workdir
+	return containerConfig, hostConfig, nil
+}
 
-	// Create and start the container
-	resp, err := dh.cli.ContainerCreate(dh.ctx, containerConfig, hostConfig, nil, nil, "")
+// setLimits sets the memory and CPU limits of a container running processes of the MPI processes.
+// The swap is disabled, as on the nodes of a cluster.
+func (r *DockerRunOptions) setLimits(hostConfig *container.HostConfig, processes int) {
+	hostConfig.Memory, hostConfig.MemorySwap = 0, 0
+	if r.memoryAllocationSize > 0 {
+		hostConfig.Memory = memoryLimit(r.memoryAllocationMode, r.memoryAllocationSize, r.parallel, processes)
+		hostConfig.MemorySwap = hostConfig.Memory
+	}
+	hostConfig.NanoCPUs = int64(r.cpus * 1e9)
+}
+
+// startContainer creates and starts a container, and removes it if it cannot be started
+func (dh *DockerHelper) startContainer(containerConfig *container.Config, hostConfig *container.HostConfig, networkConfig *network.NetworkingConfig, name string) (string, error) {
+	resp, err := dh.cli.ContainerCreate(dh.ctx, containerConfig, hostConfig, networkConfig, nil, name)
 	if err != nil {
 		return "", err
 	}
 	if err := dh.cli.ContainerStart(dh.ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		dh.removeContainer(resp.ID)
 		return "", err
 	}
-
 	return resp.ID, nil
 }
 
 func (dh *DockerHelper) getContainerLogs(containerID string) error {
+	return dh.containerLogs(containerID, true, "all")
+}
+
+// containerLogs prints the output of the container, the last tail lines of it, and follows it if follow is true
+func (dh *DockerHelper) containerLogs(containerID string, follow bool, tail string) error {
+	return dh.writeContainerLogs(containerID, follow, tail, os.Stdout, os.Stderr)
+}
+
+// writeContainerLogs copies the output of the container to stdout and stderr, as containerLogs
+func (dh *DockerHelper) writeContainerLogs(containerID string, follow bool, tail string, stdout io.Writer, stderr io.Writer) error {
 	logOptions := types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
-		Follow:     true,
+		Follow:     follow,
+		Tail:       tail,
 	}
 	logReader, err := dh.cli.ContainerLogs(dh.ctx, containerID, logOptions)
 	if err != nil {
@@ -190,7 +294,7 @@ func (dh *DockerHelper) getContainerLogs(containerID string) error {
 	defer logReader.Close()
 
 	// Use a demultiplexer to split stdout and stderr, and copy the container logs to the program output
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, logReader)
+	_, err = stdcopy.StdCopy(stdout, stderr, logReader)
 	if err != nil && err != io.EOF {
 		return fmt.Errorf("copying container logs: %v", err)
 	}
@@ -198,19 +302,98 @@ func (dh *DockerHelper) getContainerLogs(containerID string) error {
 	return nil
 }
 
-func (dh *DockerHelper) waitForContainerExit(containerID string) error {
+// waitForContainerExit waits until the container exits, or kills it after timeout if timeout is not 0
+func (dh *DockerHelper) waitForContainerExit(containerID string, timeout time.Duration) error {
 	waitCh, errCh := dh.cli.ContainerWait(dh.ctx, containerID, container.WaitConditionNotRunning)
 	var waitResp container.WaitResponse
 	var waitErr error
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
 
 	select {
 	case waitResp = <-waitCh:
 		if waitResp.StatusCode != 0 {
+			if err := dh.oomError(containerID); err != nil {
+				return err
+			}
 			return fmt.Errorf("container exited with non-zero status: %d", waitResp.StatusCode)
 		}
 	case waitErr = <-errCh:
 		return waitErr
+	case <-timeoutCh:
+		if err := dh.cli.ContainerKill(dh.ctx, containerID, "SIGKILL"); err != nil {
+			return err
+		}
+		return &containerTimeoutError{timeout: timeout}
 	}
 
 	return nil
 }
+
+// containerTimeoutError is returned when a container is killed for running longer than its timeout
+type containerTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *containerTimeoutError) Error() string {
+	return fmt.Sprintf("container timed out after %v", e.timeout)
+}
+
+// exitCodeError is an error making rhino exit with a given code
+type exitCodeError struct {
+	err  error
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// ExitCode returns the exit code of rhino for the error of a command: 0 if err is nil, 1 by default
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exitCodeError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return 1
+}
+
+// oomError returns an error if the container was killed for exceeding its memory limit
+func (dh *DockerHelper) oomError(containerID string) error {
+	inspect, err := dh.cli.ContainerInspect(dh.ctx, containerID)
+	if err != nil || inspect.State == nil || !inspect.State.OOMKilled {
+		return nil
+	}
+	limit := "the memory of the host"
+	if inspect.HostConfig != nil && inspect.HostConfig.Memory > 0 {
+		limit = "its memory limit of " + formatSize(inspect.HostConfig.Memory)
+	}
+	return fmt.Errorf("container %s was killed because it ran out of memory (%s), please increase --mem-size or change --mem-mode", strings.TrimPrefix(inspect.Name, "/"), limit)
+}
+
+// stopContainer sends SIGTERM to the container, and kills it if it is still running after timeout
+func (dh *DockerHelper) stopContainer(containerID string, timeout time.Duration) error {
+	seconds := int(timeout.Seconds())
+	return dh.cli.ContainerStop(dh.ctx, containerID, container.StopOptions{Timeout: &seconds})
+}
+
+func (dh *DockerHelper) killContainer(containerID string) error {
+	return dh.cli.ContainerKill(dh.ctx, containerID, "SIGKILL")
+}
+
+// removeImage removes the tag of image, and the image itself if it has no other tag
+func (dh *DockerHelper) removeImage(image string) error {
+	_, err := dh.cli.ImageRemove(dh.ctx, image, types.ImageRemoveOptions{})
+	return err
+}
@@ -22,26 +22,30 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/OpenRHINO/RHINO-CLI/generate"
 	"github.com/spf13/cobra"
 )
 
 type CreateOptions struct {
-	language string
+	language    string
+	buildSystem string
 }
 
 func NewCreateCommand() *cobra.Command {
 	createOpts := &CreateOptions{}
 	createCmd := &cobra.Command{
-		Use:     "create",
-		Short:   "Create a new MPI function/project",
-		Long:    "\nCreate a new MPI function/project",
-		Example: `  C++ function: rhino create func_name -l cpp`,
-		Args:    createOpts.argsCheck,
-		RunE:    createOpts.runCreate,
+		Use:   "create",
+		Short: "Create a new MPI function/project",
+		Long:  "\nCreate a new MPI function/project",
+		Example: `  C++ function: rhino create func_name -l cpp
+  C++ function built with CMake: rhino create func_name -l cpp --build-system cmake`,
+		Args: createOpts.argsCheck,
+		RunE: createOpts.runCreate,
 	}
 	createCmd.Flags().StringVarP(&createOpts.language, "lang", "l", "cpp", "language template to use")
+	createCmd.Flags().StringVar(&createOpts.buildSystem, "build-system", BuildSystemMake, "build system of the template, choose from [make, cmake]")
 	return createCmd
 }
 
@@ -57,6 +61,9 @@ func (c *CreateOptions) argsCheck(cmd *cobra.Command, args []string) error {
 	if c.language != "cpp" {
 		return fmt.Errorf("only supports cpp in this version")
 	}
+	if c.buildSystem != BuildSystemMake && c.buildSystem != BuildSystemCMake {
+		return fmt.Errorf("only supports make and cmake templates in this version")
+	}
 	return nil
 }
 
@@ -70,21 +77,26 @@ func (c *CreateOptions) runCreate(cmd *cobra.Command, args []string) error {
 
 	}
 
-	if err := generateTemplate(dirName); err != nil {
+	if err := generateTemplate(dirName, c.buildSystem); err != nil {
 		return fmt.Errorf("generate template failed: %s", err.Error())
 	}
 
 	return nil
 }
 
-func generateTemplate(dstDir string) error {
+// generateTemplate writes the function template into dstDir, with the build file of buildSystem
+func generateTemplate(dstDir string, buildSystem string) error {
 	zr, err := zip.NewReader(bytes.NewReader(generate.TemplatesZip), int64(len(generate.TemplatesZip)))
 	if err != nil {
 		return err
 	}
 
 	for _, file := range zr.File {
-		path := filepath.Join(dstDir, file.Name)
+		name := templateFileName(file.Name, buildSystem)
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(dstDir, name)
 		// 如果是目录，则创建目录，并跳过当前循环，继续处理下一个
 		if file.FileInfo().IsDir() {
 			if err := os.MkdirAll(path, file.Mode()); err != nil {
@@ -112,3 +124,19 @@ func generateTemplate(dstDir string) error {
 
 	return nil
 }
+
+// templateFileName returns the path of a file of the templates in the project, or "" if the file is not used
+func templateFileName(name string, buildSystem string) string {
+	if strings.HasPrefix(name, "func/") {
+		name = strings.TrimPrefix(name, "func/")
+		// The Makefile is replaced by the build file of the other build systems
+		if buildSystem != BuildSystemMake && name == "src/Makefile" {
+			return ""
+		}
+		return name
+	}
+	if buildSystem != BuildSystemMake && strings.HasPrefix(name, buildSystem+"/") {
+		return strings.TrimPrefix(name, buildSystem+"/")
+	}
+	return ""
+}
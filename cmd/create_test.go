@@ -18,6 +18,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -63,6 +64,15 @@ func TestCreateFunc(t *testing.T) {
 	assert.Equal(t, nil, err, "test create func failed: %s", errorMessage(err))
 }
 
+// cleanBuildOutputs removes the outputs rhino build writes into the current folder once the test is done,
+// the template folder must stay the same as the folders 'rhino create' generates
+func cleanBuildOutputs(t *testing.T) {
+	outputs, err := filepath.Abs(".rhino")
+	if err == nil {
+		t.Cleanup(func() { os.RemoveAll(outputs) })
+	}
+}
+
 func errorMessage(err error) string {
 	if err == nil {
 		return ""
@@ -112,3 +122,23 @@ func checkGenerateFolerContent(t *testing.T, generateFolerName string, templateF
 		}
 	}
 }
+
+// check if the Makefile is replaced by CMakeLists.txt in the CMake template
+func TestCreateCMakeFunc(t *testing.T) {
+	testFuncName := t.TempDir() + "/test-create-func-cmake"
+	rootCmd := NewRootCommand()
+	rootCmd.SetArgs([]string{"create", testFuncName, "--build-system", "cmake"})
+	err := rootCmd.Execute()
+	assert.Equal(t, nil, err, "test create func failed: %s", errorMessage(err))
+
+	_, err = os.Stat(testFuncName + "/src/Makefile")
+	assert.Equal(t, true, os.IsNotExist(err), "test create func failed: Makefile found in the CMake template")
+	_, err = os.Stat(testFuncName + "/src/CMakeLists.txt")
+	assert.Equal(t, nil, err, "test create func failed: %s", errorMessage(err))
+	_, err = os.Stat(testFuncName + "/src/main.cpp")
+	assert.Equal(t, nil, err, "test create func failed: %s", errorMessage(err))
+
+	rootCmd.SetArgs([]string{"create", testFuncName + "-meson", "--build-system", "meson"})
+	err = rootCmd.Execute()
+	assert.Equal(t, fmt.Errorf("only supports make and cmake templates in this version"), err)
+}
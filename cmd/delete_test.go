@@ -35,6 +35,7 @@ func TestDeleteSingleJob(t *testing.T) {
 	rootCmd := NewRootCommand()
 	// use `rhino build` to build template
 	os.Chdir("templates/func")
+	cleanBuildOutputs(t)
 	testFuncName := "test-delete-func-cpp"
 	testFuncImageName := "test-delete-func-cpp:v1"
 	rootCmd.SetArgs([]string{"build", "--image", testFuncImageName})
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"strings"
+)
+
+// The Spack specs of the manifest are linked into this directory of the builder stage
+const spackViewDir = "/opt/rhino-spack"
+
+func (d *DependenciesSpec) empty() bool {
+	return len(d.Build) == 0 && len(d.Runtime) == 0 && len(d.Spack) == 0
+}
+
+// packageInstallStep returns the Dockerfile instruction installing packages with the package manager
+func packageInstallStep(manager string, packages []string) string {
+	// Version constraints such as hdf5-dev>1.10 must not become redirections
+	quoted := []string{}
+	for _, name := range packages {
+		quoted = append(quoted, shellQuote(name))
+	}
+	apk := "apk add --no-cache " + strings.Join(quoted, " ")
+	apt := "apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends " +
+		strings.Join(quoted, " ") + " && rm -rf /var/lib/apt/lists/*"
+	switch manager {
+	case "apk":
+		return "RUN " + apk
+	case "apt":
+		return "RUN " + apt
+	default:
+		return "RUN if command -v apk >/dev/null; then " + apk + "; " +
+			"elif command -v apt-get >/dev/null; then " + apt + "; " +
+			"else echo 'no supported package manager (apk, apt) found' >&2; exit 1; fi"
+	}
+}
+
+// dependencySteps returns the Dockerfile instructions installing the dependencies in the builder and the runtime stages
+func dependencySteps(d *DependenciesSpec) (builder []string, runtime []string) {
+	if len(d.Build) > 0 {
+		builder = append(builder, packageInstallStep(d.PackageManager, d.Build))
+	}
+	if len(d.Spack) > 0 {
+		specs := []string{}
+		for _, spec := range d.Spack {
+			specs = append(specs, shellQuote(spec))
+		}
+		builder = append(builder,
+			"RUN spack install --fail-fast "+strings.Join(specs, " ")+
+				" && spack view --dependencies yes symlink --ignore-conflicts "+spackViewDir+" "+strings.Join(specs, " "),
+			"ENV CPATH="+spackViewDir+"/include LIBRARY_PATH="+spackViewDir+"/lib:"+spackViewDir+"/lib64 "+
+				"PKG_CONFIG_PATH="+spackViewDir+"/lib/pkgconfig CMAKE_PREFIX_PATH="+spackViewDir,
+		)
+	}
+	if len(d.Runtime) > 0 {
+		runtime = append(runtime, packageInstallStep(d.PackageManager, d.Runtime))
+	}
+	return builder, runtime
+}
+
+// injectDependencies adds the steps installing the dependencies after the FROM instruction of the
+// builder stage, which is the first one, and of the runtime stage, which is the last one
+func injectDependencies(dockerfile string, d *DependenciesSpec) string {
+	builder, runtime := dependencySteps(d)
+	lines := strings.Split(dockerfile, "\n")
+	stages := []int{}
+	for i, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.ToUpper(fields[0]) == "FROM" {
+			stages = append(stages, i)
+		}
+	}
+	if len(stages) == 0 {
+		return dockerfile
+	}
+
+	steps := map[int][]string{}
+	if len(builder) > 0 {
+		steps[stages[0]] = append([]string{dependenciesHeader}, builder...)
+	}
+	if len(runtime) > 0 {
+		last := stages[len(stages)-1]
+		if len(steps[last]) == 0 {
+			steps[last] = []string{dependenciesHeader}
+		}
+		steps[last] = append(steps[last], runtime...)
+	}
+
+	result := []string{}
+	for i, line := range lines {
+		result = append(result, line)
+		result = append(result, steps[i]...)
+	}
+	return strings.Join(result, "\n")
+}
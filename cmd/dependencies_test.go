@@ -0,0 +1,40 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectDependencies(t *testing.T) {
+	dockerfile := "ARG builder_image=foo\nFROM ${builder_image} as builder\nRUN make\n\nFROM bar\nCMD [\"/bin/sh\"]"
+	deps := &DependenciesSpec{PackageManager: "apk", Build: []string{"fftw-dev"}, Runtime: []string{"fftw"}}
+	expected := "ARG builder_image=foo\nFROM ${builder_image} as builder\n" +
+		"# Generated by rhino build from the dependencies in rhino.yaml\nRUN apk add --no-cache fftw-dev\nRUN make\n\nFROM bar\n" +
+		"# Generated by rhino build from the dependencies in rhino.yaml\nRUN apk add --no-cache fftw\nCMD [\"/bin/sh\"]"
+	assert.Equal(t, expected, injectDependencies(dockerfile, deps))
+
+	builder, runtime := dependencySteps(&DependenciesSpec{Spack: []string{"petsc@3.19 +mpi"}})
+	assert.Equal(t, 2, len(builder))
+	assert.Equal(t, "RUN spack install --fail-fast 'petsc@3.19 +mpi' && spack view --dependencies yes symlink --ignore-conflicts /opt/rhino-spack 'petsc@3.19 +mpi'", builder[0])
+	assert.Equal(t, 0, len(runtime))
+
+	// version constraints are quoted, not taken as redirections
+	assert.Equal(t, "RUN apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends 'hdf5-dev>1.10' libfftw3-dev && rm -rf /var/lib/apt/lists/*",
+		packageInstallStep("apt", []string{"hdf5-dev>1.10", "libfftw3-dev"}))
+}
@@ -0,0 +1,467 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// The options of the last 'rhino dev' session, reused when they are not given again
+const devSettingsFile = ".rhino/dev.json"
+
+// How long a local program has to exit after SIGTERM before it is killed
+const devStopGracePeriod = 3 * time.Second
+
+type DevOptions struct {
+	settings devSettings
+	interval time.Duration
+	debounce time.Duration
+}
+
+type devSettings struct {
+	Image    string   `json:"image,omitempty"`
+	Local    bool     `json:"local"`
+	Parallel int      `json:"np"`
+	Volume   string   `json:"volume,omitempty"`
+	MPI      string   `json:"mpi"`
+	Args     []string `json:"args"`
+}
+
+func NewDevCommand() *cobra.Command {
+	devOpts := &DevOptions{}
+	devCmd := &cobra.Command{
+		Use:   "dev",
+		Short: "Rebuild and rerun the function whenever its source changes",
+		Long: "\nWatch the function project, rebuild it when a file changes and rerun it locally with Docker, or without containers if --local is given." +
+			"\nThe files ignored by .gitignore and .dockerignore are not watched. The options are saved in " + devSettingsFile + " and reused by the next 'rhino dev'.",
+		Example: `  rhino dev
+  rhino dev -i foo/matmul:dev --np 4 -- arg1 arg2
+  rhino dev --local --np 4`,
+		RunE: devOpts.runDev,
+	}
+
+	devCmd.Flags().StringVarP(&devOpts.settings.Image, "image", "i", "", "the image to build, default <directory name>:dev")
+	devCmd.Flags().BoolVar(&devOpts.settings.Local, "local", false, "build and run with the MPI installed on this machine, without docker")
+	devCmd.Flags().IntVar(&devOpts.settings.Parallel, "np", 1, "the number of MPI processes")
+	devCmd.Flags().StringVarP(&devOpts.settings.Volume, "volume", "v", "", "Bind mount a volume in the format <host-path>:<container-path>")
+	devCmd.Flags().StringVar(&devOpts.settings.MPI, "mpi", MPIOpenMPI, "the MPI implementation, choose from [openmpi, mpich]")
+	devCmd.Flags().DurationVar(&devOpts.interval, "interval", 500*time.Millisecond, "how often the files are checked for changes")
+	devCmd.Flags().DurationVar(&devOpts.debounce, "debounce", time.Second, "how long the files must stay unchanged before rebuilding")
+
+	return devCmd
+}
+
+// loadSettings fills the options not given on the command line with the ones of the last session
+func (d *DevOptions) loadSettings(cmd *cobra.Command, args []string) error {
+	last := devSettings{}
+	data, err := os.ReadFile(devSettingsFile)
+	if err == nil {
+		if err := json.Unmarshal(data, &last); err != nil {
+			return fmt.Errorf("invalid %s: %v", devSettingsFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	flags := cmd.Flags()
+	if !flags.Changed("image") && last.Image != "" {
+		d.settings.Image = last.Image
+	}
+	if !flags.Changed("local") {
+		d.settings.Local = last.Local
+	}
+	if !flags.Changed("np") && last.Parallel > 0 {
+		d.settings.Parallel = last.Parallel
+	}
+	if !flags.Changed("volume") && last.Volume != "" {
+		d.settings.Volume = last.Volume
+	}
+	if !flags.Changed("mpi") && last.MPI != "" {
+		d.settings.MPI = last.MPI
+	}
+	d.settings.Args = args
+	if len(args) == 0 && cmd.ArgsLenAtDash() < 0 {
+		d.settings.Args = last.Args
+	}
+
+	if !d.settings.Local && d.settings.Image == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		d.settings.Image = strings.ToLower(filepath.Base(cwd)) + ":dev"
+	}
+	if err := validateRunOptions(d.settings.Parallel, d.settings.MPI); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(devSettingsFile), 0755); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(d.settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(devSettingsFile, data, 0644)
+}
+
+func (d *DevOptions) runDev(cmd *cobra.Command, args []string) error {
+	if err := d.loadSettings(cmd, args); err != nil {
+		return err
+	}
+	build := d.buildOptions()
+	if err := build.validateArgs(cmd, nil); err != nil {
+		return err
+	}
+	ignore, err := loadIgnoreRules(".")
+	if err != nil {
+		return err
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	var run *devRun
+	var runDone <-chan error
+	var changedAt time.Time
+	files := map[string]fileStamp{}
+	outputs := map[string]bool{}
+	rebuild := true
+	for {
+		if rebuild {
+			if run != nil {
+				fmt.Println("Stopping the previous run")
+				run.stop()
+				run, runDone = nil, nil
+			}
+			// The files are taken before the build, so that the changes saved during the build trigger the next one
+			if files, err = snapshotFiles(".", ignore); err != nil {
+				return err
+			}
+			built := make(chan *devRun, 1)
+			go func() {
+				built <- d.buildAndStart(cmd, build)
+			}()
+			select {
+			case run = <-built:
+			case <-signalCh:
+				// The build tools get Ctrl+C from the terminal as well
+				fmt.Println("Interrupted during the build")
+				return nil
+			}
+			if run != nil {
+				runDone = run.done
+			}
+			// The build may write into the source tree, e.g. the objects of 'make', which must not trigger a rebuild
+			after, err := snapshotFiles(".", ignore)
+			if err != nil {
+				return err
+			}
+			absorbBuildOutputs(files, after, outputs)
+			rebuild = false
+			fmt.Println("Watching for changes, press Ctrl+C to stop")
+		}
+
+		select {
+		case <-signalCh:
+			if run != nil {
+				run.stop()
+			}
+			return nil
+		case err := <-runDone:
+			if err != nil {
+				fmt.Println("Run failed:", err)
+			} else {
+				fmt.Println("Run completed")
+			}
+			run, runDone = nil, nil
+		case <-ticker.C:
+			current, err := snapshotFiles(".", ignore)
+			if err != nil {
+				return err
+			}
+			if changed := changedFiles(files, current); len(changed) > 0 {
+				fmt.Println("Changed:", strings.Join(changed, ", "))
+				files = current
+				changedAt = time.Now()
+			}
+			if !changedAt.IsZero() && time.Since(changedAt) >= d.debounce {
+				changedAt = time.Time{}
+				rebuild = true
+			}
+		}
+	}
+}
+
+// buildOptions returns the options of the builds, with the defaults of 'rhino build'
+func (d *DevOptions) buildOptions() *BuildOptions {
+	return &BuildOptions{
+		image:        d.settings.Image,
+		mpi:          d.settings.MPI,
+		local:        d.settings.Local,
+		jobs:         2,
+		progress:     ProgressPlain,
+		testParallel: 1,
+		testTimeout:  time.Minute,
+	}
+}
+
+// buildAndStart builds the function and starts it, errors are printed and the watching goes on
+func (d *DevOptions) buildAndStart(cmd *cobra.Command, build *BuildOptions) *devRun {
+	var run *devRun
+	var err error
+	if d.settings.Local {
+		if err = build.buildLocal(nil); err == nil {
+			run, err = d.startLocal(cmd)
+		}
+	} else {
+		if err = build.build(nil); err == nil {
+			run, err = d.startDocker(cmd)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil
+	}
+	return run
+}
+
+// devRun is a run of the function which can be cancelled
+type devRun struct {
+	done chan error
+	stop func()
+}
+
+func (d *DevOptions) startLocal(cmd *cobra.Command) (*devRun, error) {
+	localRun := &LocalRunOptions{parallel: d.settings.Parallel, mpi: d.settings.MPI}
+	runCmd, err := localRun.command(cmd, d.settings.Args)
+	if err != nil {
+		return nil, err
+	}
+	if err := runCmd.Start(); err != nil {
+		return nil, err
+	}
+	run := &devRun{done: make(chan error, 1)}
+	exited := make(chan struct{})
+	go func() {
+		run.done <- exitStatus(runCmd.Wait())
+		close(exited)
+	}()
+	run.stop = func() {
+		// mpirun forwards SIGTERM to the processes it started
+		runCmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(devStopGracePeriod):
+			runCmd.Process.Kill()
+			<-exited
+		}
+	}
+	return run, nil
+}
+
+func (d *DevOptions) startDocker(cmd *cobra.Command) (*devRun, error) {
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return nil, err
+	}
+	dockerRun := &DockerRunOptions{parallel: d.settings.Parallel, mpi: d.settings.MPI}
+	if d.settings.Volume != "" {
+		dockerRun.volumes = []string{d.settings.Volume}
+	}
+	dockerRun.executable = applyFunctionLabels(cmd, d.settings.Image, localImageLabels(d.settings.Image), &dockerRun.mpi)
+	containerID, err := helper.createAndStartContainer(dockerRun, append([]string{d.settings.Image}, d.settings.Args...))
+	if err != nil {
+		return nil, err
+	}
+	go helper.getContainerLogs(containerID)
+
+	run := &devRun{done: make(chan error, 1)}
+	exited := make(chan struct{})
+	go func() {
+		err := helper.waitForContainerExit(containerID, 0)
+		helper.removeContainer(containerID)
+		run.done <- err
+		close(exited)
+	}()
+	run.stop = func() {
+		helper.removeContainer(containerID)
+		<-exited
+	}
+	return run, nil
+}
+
+// fileStamp tells whether a file has changed since it was last seen
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotFiles returns the stamps of the files under dir which are not ignored
+func snapshotFiles(dir string, ignore *ignoreRules) (map[string]fileStamp, error) {
+	files := map[string]fileStamp{}
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The file may have been removed while walking
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		if ignore.match(filepath.ToSlash(rel), entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		files[filepath.ToSlash(rel)] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return files, err
+}
+
+// absorbBuildOutputs takes the files created by a build into the snapshot taken before it, so that only the
+// other changes made during the build are seen. The files a build once created are build outputs from then on.
+func absorbBuildOutputs(files map[string]fileStamp, after map[string]fileStamp, outputs map[string]bool) {
+	for name, stamp := range after {
+		if _, existed := files[name]; !existed || outputs[name] {
+			outputs[name] = true
+			files[name] = stamp
+		}
+	}
+	for name := range outputs {
+		if _, ok := after[name]; !ok {
+			delete(files, name)
+		}
+	}
+}
+
+// changedFiles returns the files added, removed or modified between two snapshots
+func changedFiles(old map[string]fileStamp, current map[string]fileStamp) []string {
+	changed := []string{}
+	for name, stamp := range current {
+		if oldStamp, ok := old[name]; !ok || oldStamp != stamp {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ignoreRules holds the patterns of .gitignore and .dockerignore, the last matching pattern wins like in git
+type ignoreRules struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// The files which are never watched
+var defaultIgnorePatterns = []string{".git/", ".rhino/"}
+
+func loadIgnoreRules(dir string) (*ignoreRules, error) {
+	lines := append([]string{}, defaultIgnorePatterns...)
+	for _, name := range []string{".gitignore", ".dockerignore"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+	return newIgnoreRules(lines), nil
+}
+
+func newIgnoreRules(lines []string) *ignoreRules {
+	rules := &ignoreRules{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A pattern with a slash is relative to the project directory, otherwise it matches a name at any level
+		p.anchored = strings.Contains(line, "/")
+		p.pattern = strings.TrimPrefix(line, "/")
+		if p.pattern != "" {
+			rules.patterns = append(rules.patterns, p)
+		}
+	}
+	return rules
+}
+
+// match tells whether the file at rel, a slash separated path relative to the project directory, is ignored
+func (r *ignoreRules) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, p := range r.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		name := path.Base(rel)
+		if p.anchored {
+			name = rel
+		}
+		if ok, _ := path.Match(p.pattern, name); ok {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
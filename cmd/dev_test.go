@@ -0,0 +1,106 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := newIgnoreRules(append(defaultIgnorePatterns, "# objects", "*.o", "/build/", "!keep.o", "src/mpi-func", ""))
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{".rhino", true, true},
+		{"src/main.cpp", false, false},
+		{"src/main.o", false, true},
+		{"src/keep.o", false, false},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"build", false, false},
+		{"src/mpi-func", false, true},
+		{"mpi-func", false, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.ignored, rules.match(c.path, c.isDir), "test failed: wrong match of %s", c.path)
+	}
+}
+
+func TestSnapshotFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".rhino", "build"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "main.cpp"), []byte("int main() {}"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "main.o"), []byte{}, 0644)
+	os.WriteFile(filepath.Join(dir, ".rhino", "build", "mpi-func"), []byte{}, 0755)
+	rules := newIgnoreRules(append(defaultIgnorePatterns, "*.o"))
+
+	old, err := snapshotFiles(dir, rules)
+	assert.Equal(t, nil, err, "test snapshot failed: %s", errorMessage(err))
+	assert.Equal(t, 1, len(old), "test failed: ignored files are watched")
+
+	os.WriteFile(filepath.Join(dir, "src", "main.cpp"), []byte("int main() { return 0; }"), 0644)
+	os.Chtimes(filepath.Join(dir, "src", "main.cpp"), time.Now(), time.Now().Add(time.Second))
+	os.WriteFile(filepath.Join(dir, "Makefile"), []byte{}, 0644)
+	current, err := snapshotFiles(dir, rules)
+	assert.Equal(t, nil, err, "test snapshot failed: %s", errorMessage(err))
+	assert.Equal(t, []string{"Makefile", "src/main.cpp"}, changedFiles(old, current))
+	assert.Equal(t, []string{}, changedFiles(current, current))
+}
+
+func TestDevBuildOptions(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	os.Mkdir("src", 0755)
+	os.WriteFile(filepath.Join("src", "Makefile"), []byte("mpi-func: main.c\n\tmpicc -o mpi-func main.c\n"), 0644)
+
+	for _, local := range []bool{false, true} {
+		d := &DevOptions{settings: devSettings{Image: "hello:dev", Local: local, Parallel: 2, MPI: MPIOpenMPI}}
+		build := d.buildOptions()
+		err := build.validateArgs(NewDevCommand(), nil)
+		assert.Equal(t, nil, err, errorMessage(err))
+		assert.Equal(t, BuildSystemMake, build.buildSystem)
+		assert.Equal(t, funcExecName, build.executable)
+	}
+}
+
+func TestAbsorbBuildOutputs(t *testing.T) {
+	now := time.Now()
+	files := map[string]fileStamp{"src/main.cpp": {modTime: now}, "src/util.cpp": {modTime: now}}
+	outputs := map[string]bool{}
+	// The build creates an object while main.cpp is edited
+	after := map[string]fileStamp{"src/main.cpp": {modTime: now.Add(time.Second)}, "src/util.cpp": {modTime: now}, "src/main.obj": {modTime: now}}
+	absorbBuildOutputs(files, after, outputs)
+	assert.Equal(t, []string{"src/main.cpp"}, changedFiles(files, after))
+	assert.Equal(t, map[string]bool{"src/main.obj": true}, outputs)
+
+	// The next build rewrites the object, then a clean build removes it
+	files = after
+	after = map[string]fileStamp{"src/main.cpp": files["src/main.cpp"], "src/util.cpp": {modTime: now}, "src/main.obj": {modTime: now.Add(time.Minute)}}
+	absorbBuildOutputs(files, after, outputs)
+	assert.Equal(t, []string{}, changedFiles(files, after))
+	delete(after, "src/main.obj")
+	absorbBuildOutputs(files, after, outputs)
+	assert.Equal(t, []string{}, changedFiles(files, after))
+}
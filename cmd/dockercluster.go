@@ -0,0 +1,309 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// 'rhino docker-run --nodes' lays out the containers like the operator lays out the pods of a job: the workers run
+// an SSH server, and the launcher runs mpirun with a hostfile listing the workers.
+
+// The SSH keys and the hostfile are copied into the containers here
+const (
+	clusterConfigDir = "/etc/rhino"
+	clusterHostfile  = clusterConfigDir + "/hostfile"
+	clusterSSHDir    = clusterConfigDir + "/ssh"
+)
+
+// The number of seconds the launcher waits for the SSH server of each worker
+const clusterSSHWait = 60
+
+// dockerCluster is the network and the containers of a multi-container run
+type dockerCluster struct {
+	helper     *DockerHelper
+	prefix     string
+	networkID  string
+	containers []string
+	// the containers and the network are left running by --detach
+	detached bool
+}
+
+// workerHosts returns the host names of the workers of the cluster
+func workerHosts(prefix string, nodes int) []string {
+	hosts := make([]string, nodes)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("%s-worker-%d", prefix, i)
+	}
+	return hosts
+}
+
+// runCluster runs the function on r.nodes containers connected by a private network named after the run,
+// and removes them afterwards
+func (r *DockerRunOptions) runCluster(helper *DockerHelper, args []string) error {
+	prefix := r.name
+	containerConfig, hostConfig, err := r.containerConfig(args)
+	if err != nil {
+		return err
+	}
+	hosts := workerHosts(prefix, r.nodes)
+	files, err := clusterFiles(r.mpi, hosts, r.slots)
+	if err != nil {
+		return err
+	}
+
+	c := &dockerCluster{helper: helper, prefix: prefix}
+	defer c.teardown()
+	resp, err := helper.cli.NetworkCreate(helper.ctx, prefix, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         map[string]string{runLabel: r.name},
+	})
+	if err != nil {
+		return err
+	}
+	c.networkID = resp.ID
+
+	fmt.Printf("Starting %d workers with %d slots each on network %s\n", r.nodes, r.slots, prefix)
+	workers := []string{}
+	sampled := map[string]string{}
+	for i, host := range hosts {
+		worker := *containerConfig
+		worker.Entrypoint = []string{"/bin/sh", "-c", workerScript()}
+		worker.Cmd = nil
+		worker.Labels = r.runLabels(runRoleWorker)
+		workerHostConfig := *hostConfig
+		r.setLimits(&workerHostConfig, workerProcesses(r.parallel, r.slots, i))
+		workerID, err := c.start(host, &worker, &workerHostConfig, files)
+		if err != nil {
+			return fmt.Errorf("failed to start the worker %s: %v", host, err)
+		}
+		workers = append(workers, workerID)
+		sampled[workerID] = host
+	}
+
+	launcher := *containerConfig
+	// The environment of the processes on the workers comes from mpirun, not from docker
+	userEnv, err := r.userEnv()
+	if err != nil {
+		return err
+	}
+	launcherArgs, err := r.launcherArgs()
+	if err != nil {
+		return err
+	}
+	mpirun := mpirunHostfileCommand(r.mpi, r.parallel, clusterHostfile, r.executable)
+	mpirun = append(append(append(append([]string{mpirun[0]}, exportEnvArgs(r.mpi, userEnv)...), launcherArgs...), mpirun[1:]...), args[1:]...)
+	launcher.Entrypoint = []string{"/bin/sh", "-c", launcherScript(hosts, mpirun)}
+	launcher.Cmd = nil
+	launcher.Labels = r.runLabels(runRoleLauncher)
+	launcher.Env = append(append([]string{}, launcher.Env...), clusterMPIEnv(r.mpi)...)
+	// The launcher only runs mpirun, the MPI processes run on the workers
+	launcherHostConfig := *hostConfig
+	launcherHostConfig.Memory, launcherHostConfig.MemorySwap, launcherHostConfig.NanoCPUs = 0, 0, 0
+	launcherID, err := c.start(prefix+"-launcher", &launcher, &launcherHostConfig, files)
+	if err != nil {
+		return fmt.Errorf("failed to start the launcher: %v", err)
+	}
+	if r.detach {
+		c.detached = true
+		r.printDetached()
+		return nil
+	}
+	err = r.followWithStats(helper, launcherID, sampled)
+	if err != nil {
+		// mpirun fails when a worker is killed, tell why
+		for _, workerID := range workers {
+			if oomErr := helper.oomError(workerID); oomErr != nil {
+				return oomErr
+			}
+		}
+	}
+	return err
+}
+
+// workerProcesses returns the number of the np MPI processes mpirun places on the worker i, filling the slots in order
+func workerProcesses(np int, slots int, i int) int {
+	processes := np - i*slots
+	if processes > slots {
+		return slots
+	}
+	if processes < 1 {
+		// An idle worker keeps the memory of one process for its SSH server
+		return 1
+	}
+	return processes
+}
+
+// start creates a container named host on the network of the cluster, copies files into it and starts it
+func (c *dockerCluster) start(host string, containerConfig *container.Config, hostConfig *container.HostConfig, files []byte) (string, error) {
+	containerConfig.Hostname = host
+	networkConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{c.prefix: {Aliases: []string{host}}},
+	}
+	resp, err := c.helper.cli.ContainerCreate(c.helper.ctx, containerConfig, hostConfig, networkConfig, nil, host)
+	if err != nil {
+		return "", err
+	}
+	c.containers = append(c.containers, resp.ID)
+	if err := c.helper.cli.CopyToContainer(c.helper.ctx, resp.ID, "/", bytes.NewReader(files), types.CopyToContainerOptions{}); err != nil {
+		return "", err
+	}
+	if err := c.helper.cli.ContainerStart(c.helper.ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// teardown removes the containers and the network of the cluster, unless it was detached
+func (c *dockerCluster) teardown() {
+	if c.detached {
+		return
+	}
+	for _, containerID := range c.containers {
+		if err := c.helper.removeContainer(containerID); err != nil {
+			fmt.Println("Warning: failed to remove the container:", err)
+		}
+	}
+	if c.networkID != "" {
+		if err := c.helper.cli.NetworkRemove(c.helper.ctx, c.networkID); err != nil {
+			fmt.Println("Warning: failed to remove the network:", err)
+		}
+	}
+}
+
+// sshSetup copies the keys of the cluster into the home directory of the user of the container
+const sshSetup = `mkdir -p "$HOME/.ssh" && cp ` + clusterSSHDir + `/* "$HOME/.ssh/" && chmod 700 "$HOME/.ssh" && chmod 600 "$HOME/.ssh/"*`
+
+// workerScript starts the SSH server of a worker
+func workerScript() string {
+	return sshSetup + ` && ssh-keygen -A >/dev/null && mkdir -p /run/sshd && ` +
+		`SSHD=$(command -v sshd || echo /usr/sbin/sshd) && ` +
+		`if [ ! -x "$SSHD" ]; then echo "sshd not found, the image needs an SSH server for --nodes" >&2; exit 1; fi && ` +
+		`exec "$SSHD" -D -e`
+}
+
+// launcherScript waits for the SSH servers of the workers, then runs mpirun
+func launcherScript(hosts []string, mpirun []string) string {
+	quoted := make([]string, len(mpirun))
+	for i, arg := range mpirun {
+		quoted[i] = shellQuote(arg)
+	}
+	return sshSetup + ` && for host in ` + strings.Join(hosts, " ") + `; do ` +
+		`n=0; until ssh -o ConnectTimeout=2 "$host" true 2>/dev/null; do ` +
+		fmt.Sprintf(`n=$((n+1)); if [ $n -ge %d ]; then echo "cannot reach $host by SSH, the image needs an SSH server and client for --nodes" >&2; exit 255; fi; sleep 1; `, clusterSSHWait) +
+		`done; done && exec ` + strings.Join(quoted, " ")
+}
+
+// clusterMPIEnv returns the environment variables the MPI implementation needs to run across containers
+func clusterMPIEnv(mpi string) []string {
+	if mpi == MPIMPICH {
+		return []string{}
+	}
+	return []string{
+		// The shared memory store of PMIx does not work across the mount namespaces of containers
+		"PMIX_MCA_gds=hash",
+		"OMPI_MCA_btl_vader_single_copy_mechanism=none",
+	}
+}
+
+// clusterFiles returns a tar archive of the SSH keys, the SSH client configuration and the hostfile of the cluster
+func clusterFiles(mpi string, hosts []string, slots int) ([]byte, error) {
+	privateKey, publicKey, err := sshKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	hostfile := ""
+	for _, host := range hosts {
+		hostfile += hostfileEntry(mpi, host, slots) + "\n"
+	}
+	sshConfig := "Host *\n  StrictHostKeyChecking no\n  UserKnownHostsFile /dev/null\n  LogLevel ERROR\n  IdentityFile ~/.ssh/id_rsa\n"
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, dir := range []string{clusterConfigDir, clusterSSHDir} {
+		if err := tw.WriteHeader(&tar.Header{Name: strings.TrimPrefix(dir, "/") + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+			return nil, err
+		}
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{clusterHostfile, []byte(hostfile)},
+		{clusterSSHDir + "/id_rsa", privateKey},
+		{clusterSSHDir + "/id_rsa.pub", publicKey},
+		{clusterSSHDir + "/authorized_keys", publicKey},
+		{clusterSSHDir + "/config", []byte(sshConfig)},
+	}
+	for _, f := range files {
+		header := &tar.Header{Name: strings.TrimPrefix(f.name, "/"), Mode: 0600, Size: int64(len(f.content))}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sshKeyPair generates the RSA key the launcher logs into the workers with, in the formats of OpenSSH
+func sshKeyPair() ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return privateKey, sshPublicKey(&key.PublicKey), nil
+}
+
+// sshPublicKey encodes key as a line of authorized_keys
+func sshPublicKey(key *rsa.PublicKey) []byte {
+	var wire bytes.Buffer
+	writeString := func(b []byte) {
+		binary.Write(&wire, binary.BigEndian, uint32(len(b)))
+		wire.Write(b)
+	}
+	writeMPInt := func(n *big.Int) {
+		b := n.Bytes()
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		writeString(b)
+	}
+	writeString([]byte("ssh-rsa"))
+	writeMPInt(big.NewInt(int64(key.E)))
+	writeMPInt(key.N)
+	return []byte("ssh-rsa " + base64.StdEncoding.EncodeToString(wire.Bytes()) + " rhino\n")
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/OpenRHINO/RHINO-CLI/generate"
+)
+
+// The Dockerfile template embedded in rhino, and the Dockerfile of the projects which have their own one
+const (
+	dockerfileTemplate = "dockerfile/Dockerfile"
+	projectDockerfile  = "Dockerfile"
+)
+
+// The first line of the steps generated from the dependencies of the manifest
+const dependenciesHeader = "# Generated by rhino build from the dependencies in " + manifestFileName
+
+// readTemplateFile reads a file of the templates embedded in rhino
+func readTemplateFile(name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(generate.TemplatesZip), int64(len(generate.TemplatesZip)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("template %s not found: %v", name, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// renderDockerfile renders the embedded Dockerfile template with the base images and the dependencies of the project,
+// mounts are added to the RUN instruction of the build stage, e.g. --mount=type=secret,id=netrc
+func renderDockerfile(manifest *ProjectManifest, builderImage string, runtimeImage string, mounts []string) (string, error) {
+	text, err := readTemplateFile(dockerfileTemplate)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(dockerfileTemplate).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return "", err
+	}
+	if builderImage == "" {
+		builderImage = defaultBuilderImage
+	}
+	if runtimeImage == "" {
+		runtimeImage = defaultRuntimeImage
+	}
+	var out strings.Builder
+	runMounts := ""
+	if len(mounts) > 0 {
+		runMounts = strings.Join(mounts, " ") + " "
+	}
+	err = tmpl.Execute(&out, map[string]string{
+		"Version":      RHINOCLIENTVERSION,
+		"BuilderImage": builderImage,
+		"RuntimeImage": runtimeImage,
+		"RunMounts":    runMounts,
+	})
+	if err != nil {
+		return "", err
+	}
+	return injectDependencies(out.String(), &manifest.Dependencies), nil
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderDockerfile(t *testing.T) {
+	manifest := &ProjectManifest{Dependencies: DependenciesSpec{Runtime: []string{"fftw"}}}
+	dockerfile, err := renderDockerfile(manifest, "", "foo/run:v1", nil)
+	assert.Equal(t, nil, err, "test render dockerfile failed: %s", errorMessage(err))
+	assert.Equal(t, []string{defaultBuilderImage, "foo/run:v1"}, dockerfileBaseImages(dockerfile, nil))
+	assert.Equal(t, true, strings.Contains(dockerfile, "build_command"), "test failed: build systems not supported")
+	assert.Equal(t, true, strings.Contains(dockerfile, "FROM ${runtime_image}\n"+dependenciesHeader+"\n"), "test failed: dependencies not installed")
+}
+
+func TestEjectDockerfile(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+
+	// an existing Dockerfile is kept without --force
+	os.WriteFile(projectDockerfile, []byte("FROM custom\n"), 0644)
+	err := (&EjectOptions{}).runEject(nil, nil)
+	assert.Equal(t, projectDockerfile+" already exists, use --force to overwrite it", errorMessage(err))
+	content, _ := os.ReadFile(projectDockerfile)
+	assert.Equal(t, "FROM custom\n", string(content))
+
+	err = (&EjectOptions{force: true}).runEject(nil, nil)
+	assert.Equal(t, nil, err, "test eject failed: %s", errorMessage(err))
+	content, _ = os.ReadFile(projectDockerfile)
+	assert.Equal(t, true, strings.Contains(string(content), "FROM ${runtime_image}"), "test failed: Dockerfile not overwritten")
+}
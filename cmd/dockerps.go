@@ -0,0 +1,314 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/spf13/cobra"
+)
+
+// dockerRunInfo describes a run of 'rhino docker-run', from its single container or its launcher
+type dockerRunInfo struct {
+	name        string
+	containerID string
+	image       string
+	np          string
+	status      string
+	running     bool
+	runtime     time.Duration
+	exitCode    int
+}
+
+type DockerPsOptions struct {
+	running bool
+}
+
+func NewDockerPsCommand() *cobra.Command {
+	psOpts := &DockerPsOptions{}
+	psCmd := &cobra.Command{
+		Use:   "docker-ps",
+		Short: "List the runs of 'rhino docker-run'",
+		Long:  "\nList the running and finished runs of 'rhino docker-run' on this machine",
+		Example: `  rhino docker-ps
+  rhino docker-ps --running`,
+		Args: cobra.NoArgs,
+		RunE: psOpts.ps,
+	}
+	psCmd.Flags().BoolVar(&psOpts.running, "running", false, "only list the running runs")
+	return psCmd
+}
+
+func (p *DockerPsOptions) ps(cmd *cobra.Command, args []string) error {
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return err
+	}
+	runs, err := helper.listRuns("")
+	if err != nil {
+		return err
+	}
+	if p.running {
+		running := []dockerRunInfo{}
+		for _, run := range runs {
+			if run.running {
+				running = append(running, run)
+			}
+		}
+		runs = running
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs found")
+		return nil
+	}
+	return printRuns(os.Stdout, runs)
+}
+
+type DockerLogsOptions struct {
+	follow bool
+	tail   string
+}
+
+func NewDockerLogsCommand() *cobra.Command {
+	logsOpts := &DockerLogsOptions{}
+	logsCmd := &cobra.Command{
+		Use:   "docker-logs [run]",
+		Short: "Print the output of a run of 'rhino docker-run'",
+		Long:  "\nPrint the output of a run of 'rhino docker-run', and follow it while it is running",
+		Example: `  rhino docker-logs matmul
+  rhino docker-logs -f --tail 20 rhino-1a2b3c4d`,
+		Args: cobra.ExactArgs(1),
+		RunE: logsOpts.logs,
+	}
+	logsCmd.Flags().BoolVarP(&logsOpts.follow, "follow", "f", false, "follow the output until the run exits")
+	logsCmd.Flags().StringVar(&logsOpts.tail, "tail", "all", "the number of lines to print from the end of the output")
+	return logsCmd
+}
+
+func (l *DockerLogsOptions) logs(cmd *cobra.Command, args []string) error {
+	if l.tail != "all" {
+		if n, err := strconv.Atoi(l.tail); err != nil || n < 0 {
+			return fmt.Errorf("the number of lines (--tail) must be a number greater than or equal to 0, or all")
+		}
+	}
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return err
+	}
+	run, err := helper.findRun(args[0])
+	if err != nil {
+		return err
+	}
+	if err := helper.containerLogs(run.containerID, l.follow, l.tail); err != nil {
+		return err
+	}
+	if l.follow {
+		// The run may have exited while following
+		if run, err = helper.findRun(args[0]); err == nil && !run.running && run.exitCode != 0 {
+			return fmt.Errorf("container exited with non-zero status: %d", run.exitCode)
+		}
+	}
+	return nil
+}
+
+type DockerStopOptions struct {
+	timeout time.Duration
+	keep    bool
+	exited  bool
+}
+
+func NewDockerStopCommand() *cobra.Command {
+	stopOpts := &DockerStopOptions{}
+	stopCmd := &cobra.Command{
+		Use:   "docker-stop [run]...",
+		Short: "Stop and remove runs of 'rhino docker-run'",
+		Long:  "\nStop the runs of 'rhino docker-run', and remove their containers and networks",
+		Example: `  rhino docker-stop matmul
+  rhino docker-stop --keep -t 30s rhino-1a2b3c4d
+  rhino docker-stop --exited`,
+		RunE: stopOpts.stop,
+	}
+	stopCmd.Flags().DurationVarP(&stopOpts.timeout, "time", "t", 10*time.Second, "the time the program is given to exit before it is killed")
+	stopCmd.Flags().BoolVar(&stopOpts.keep, "keep", false, "only stop the runs, keep their containers")
+	stopCmd.Flags().BoolVar(&stopOpts.exited, "exited", false, "remove all the runs which have exited")
+	return stopCmd
+}
+
+func (s *DockerStopOptions) stop(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && !s.exited {
+		cmd.Help()
+		return nil
+	}
+	if s.timeout < 0 {
+		return fmt.Errorf("the grace period (--time) cannot be negative")
+	}
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return err
+	}
+	names := args
+	if s.exited {
+		runs, err := helper.listRuns("")
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			if !run.running {
+				names = append(names, run.name)
+			}
+		}
+	}
+	for _, name := range names {
+		if err := helper.stopRun(name, s.timeout, !s.keep); err != nil {
+			return err
+		}
+		if s.keep {
+			fmt.Println("Run", name, "stopped")
+		} else {
+			fmt.Println("Run", name, "stopped and removed")
+		}
+	}
+	return nil
+}
+
+// runContainers returns the containers of the run name, or of all the runs if name is empty
+func (dh *DockerHelper) runContainers(name string) ([]types.Container, error) {
+	label := runLabel
+	if name != "" {
+		label += "=" + name
+	}
+	return dh.cli.ContainerList(dh.ctx, types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.Arg("label", label))})
+}
+
+// listRuns returns the runs named name, or all the runs if name is empty, the newest first
+func (dh *DockerHelper) listRuns(name string) ([]dockerRunInfo, error) {
+	containers, err := dh.runContainers(name)
+	if err != nil {
+		return nil, err
+	}
+	runs := []dockerRunInfo{}
+	for _, c := range containers {
+		if c.Labels[runRoleLabel] == runRoleWorker {
+			continue
+		}
+		inspect, err := dh.cli.ContainerInspect(dh.ctx, c.ID)
+		if err != nil {
+			// The container may have been removed since it was listed
+			continue
+		}
+		runs = append(runs, newDockerRunInfo(inspect, time.Now()))
+	}
+	return runs, nil
+}
+
+// findRun returns the run named name
+func (dh *DockerHelper) findRun(name string) (*dockerRunInfo, error) {
+	runs, err := dh.listRuns(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no run named %s found, see 'rhino docker-ps'", name)
+	}
+	return &runs[0], nil
+}
+
+// stopRun stops the containers of the run name, and removes them with its network if remove is true
+func (dh *DockerHelper) stopRun(name string, timeout time.Duration, remove bool) error {
+	containers, err := dh.runContainers(name)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no run named %s found, see 'rhino docker-ps'", name)
+	}
+	for _, c := range containers {
+		if c.State == "running" {
+			if err := dh.stopContainer(c.ID, timeout); err != nil {
+				return err
+			}
+		}
+		if remove {
+			if err := dh.removeContainer(c.ID); err != nil {
+				return err
+			}
+		}
+	}
+	if !remove {
+		return nil
+	}
+	networks, err := dh.cli.NetworkList(dh.ctx, types.NetworkListOptions{Filters: filters.NewArgs(filters.Arg("label", runLabel+"="+name))})
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		if err := dh.cli.NetworkRemove(dh.ctx, network.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newDockerRunInfo(inspect types.ContainerJSON, now time.Time) dockerRunInfo {
+	run := dockerRunInfo{containerID: inspect.ID, np: "-"}
+	if inspect.Config != nil {
+		run.name = inspect.Config.Labels[runLabel]
+		run.image = inspect.Config.Image
+		if np := inspect.Config.Labels[runNPLabel]; np != "" {
+			run.np = np
+		}
+	}
+	if inspect.State == nil {
+		return run
+	}
+	run.status = inspect.State.Status
+	run.running = inspect.State.Running
+	run.exitCode = inspect.State.ExitCode
+	started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil || started.IsZero() || started.Year() < 2000 {
+		return run
+	}
+	end := now
+	if !run.running {
+		if finished, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt); err == nil && finished.After(started) {
+			end = finished
+		} else {
+			return run
+		}
+	}
+	run.runtime = end.Sub(started)
+	return run
+}
+
+func printRuns(w io.Writer, runs []dockerRunInfo) error {
+	tw := tabwriter.NewWriter(w, 2, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tIMAGE\tNP\tSTATUS\tRUNTIME\tEXIT CODE")
+	for _, run := range runs {
+		exitCode := "-"
+		if !run.running && run.status == "exited" {
+			exitCode = strconv.Itoa(run.exitCode)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\t%s\n", run.name, run.image, run.np, run.status, run.runtime.Round(time.Second), exitCode)
+	}
+	return tw.Flush()
+}
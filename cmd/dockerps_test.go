@@ -0,0 +1,55 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerRunInfo(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	newContainer := func(name string, state *types.ContainerState) types.ContainerJSON {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: name + "-id", State: state},
+			Config: &container.Config{
+				Image:  "foo/matmul:v2.1",
+				Labels: (&DockerRunOptions{name: name, parallel: 4}).runLabels(runRoleSingle),
+			},
+		}
+	}
+	running := newDockerRunInfo(newContainer("matmul", &types.ContainerState{
+		Status: "running", Running: true, StartedAt: "2023-06-01T11:58:30.5Z", FinishedAt: "0001-01-01T00:00:00Z",
+	}), now)
+	assert.Equal(t, dockerRunInfo{name: "matmul", containerID: "matmul-id", image: "foo/matmul:v2.1", np: "4",
+		status: "running", running: true, runtime: 89500 * time.Millisecond}, running)
+	exited := newDockerRunInfo(newContainer("rhino-1a2b3c4d", &types.ContainerState{
+		Status: "exited", ExitCode: 1, StartedAt: "2023-06-01T10:00:00Z", FinishedAt: "2023-06-01T10:00:07Z",
+	}), now)
+	assert.Equal(t, 7*time.Second, exited.runtime)
+
+	var out strings.Builder
+	printRuns(&out, []dockerRunInfo{running, exited})
+	expected := "RUN             IMAGE            NP  STATUS   RUNTIME  EXIT CODE\n" +
+		"matmul          foo/matmul:v2.1  4   running  1m30s    -\n" +
+		"rhino-1a2b3c4d  foo/matmul:v2.1  4   exited   7s       1\n"
+	assert.Equal(t, expected, out.String())
+}
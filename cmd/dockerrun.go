@@ -16,16 +16,72 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 type DockerRunOptions struct {
-	parallel int
-	volume   string
+	parallel   int
+	mpi        string
+	executable string
+	// the mapping, binding and oversubscription of the MPI processes, and the extra options of mpirun
+	mapBy         string
+	bindTo        string
+	oversubscribe bool
+	mpiArgs       []string
+	// the number of OpenMP threads of each MPI process, OMP_NUM_THREADS is not set if 0
+	threadsPerRank int
+	// the volumes, tmpfs mounts, environment variables and working directory of the container
+	volumes  []string
+	tmpfs    []string
+	env      []string
+	envFiles []string
+	workdir  string
+	// the local directory mounted at the path of the data of RHINO jobs, as the NFS directory of 'rhino run'
+	dataDir string
+	// the name of the run, which names its container or prefixes the names of its containers
+	name string
+	// remove the container when it exits
+	remove bool
+	// start the run in the background, its containers are kept until 'rhino docker-stop'
+	detach bool
+	// the time a container is given to exit after SIGINT or SIGTERM before it is killed
+	stopTimeout time.Duration
+	// the maximum duration of the run, like the TTL of a RHINO job, no limit if 0
+	timeout time.Duration
+	// when the image is pulled: always, if it is missing or never
+	pull string
+	// report the resource usage of the run, and write its time series to a CSV file
+	stats    bool
+	statsCSV string
+	// the number of containers and the number of processes of each one, like the nodes of a cluster
+	nodes int
+	slots int
+	// the memory and CPU limits, with the memory allocation modes of 'rhino run'
+	memoryAllocationMode string
+	memoryAllocationSize int
+	cpus                 float64
 }
 
+// rhino exits with this code when a run is killed by --timeout, as the timeout command does
+const timeoutExitCode = 124
+
+// The number of lines of output printed again when a run times out
+const timeoutTailLines = 20
+
+// The names docker accepts for containers
+var validContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
 func NewDockerRunCommand() *cobra.Command {
 	dockerRunOpts := &DockerRunOptions{}
 	dockerRunCmd := &cobra.Command{
@@ -34,12 +90,49 @@ func NewDockerRunCommand() *cobra.Command {
 		Long:  "\nSubmit and run an MPI job using Docker",
 		Example: `  rhino docker-run hello:v1.0
   rhino docker-run foo/matmul:v2.1 --np 4 -- arg1 arg2
-  rhino docker-run bar/image:v3.0 -v /path/on/host:/path/in/container --np 8`,
+  rhino docker-run bar/image:v3.0 -v /path/on/host:/path/in/container --np 8
+  rhino docker-run mpi/testbench --np 4 --data-dir ./data -- --in=/data/file --out=/data/out
+  rhino docker-run bar/image:v3.0 -v ./input:/input:ro --tmpfs /scratch:size=1g -e OMP_NUM_THREADS=2 -w /input
+  rhino docker-run foo/mpich-func:v1.0 --mpi mpich --np 4
+  rhino docker-run foo/matmul:v2.1 --np 4 --map-by socket --bind-to core --threads-per-rank 2
+  rhino docker-run foo/matmul:v2.1 --np 16 --oversubscribe --mpi-arg=--report-bindings
+  rhino docker-run foo/matmul:v2.1 --name matmul-debug --rm=false
+  rhino docker-run foo/matmul:v2.1 --nodes 2 --slots 4
+  rhino docker-run foo/matmul:v2.1 --np 4 --name matmul --detach
+  rhino docker-run foo/matmul:v2.1 --np 4 --timeout 10m
+  rhino docker-run foo/matmul:v2.1 --np 4 --stats --stats-csv matmul.csv
+  rhino docker-run foo/matmul:v2.1 --np 4 --mem-mode FixedTotalMemory --mem-size 8 --cpus 4`,
 		RunE: dockerRunOpts.dockerRun,
 	}
 
-	dockerRunCmd.Flags().StringVarP(&dockerRunOpts.volume, "volume", "v", "", "Bind mount a volume in the format <host-path>:<container-path>")
+	dockerRunCmd.Flags().StringArrayVarP(&dockerRunOpts.volumes, "volume", "v", nil, "Bind mount a volume in the format <host-path>:<container-path>[:ro], can be repeated")
+	dockerRunCmd.Flags().StringArrayVar(&dockerRunOpts.tmpfs, "tmpfs", nil, "Mount a tmpfs in the format <container-path>[:<options>], e.g. /scratch:size=1g")
+	dockerRunCmd.Flags().StringArrayVarP(&dockerRunOpts.env, "env", "e", nil, "Set an environment variable in the format KEY=VALUE, or KEY to pass its value here")
+	dockerRunCmd.Flags().StringArrayVar(&dockerRunOpts.envFiles, "env-file", nil, "Read environment variables from a file of KEY=VALUE lines")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.dataDir, "data-dir", "", "a local directory mounted at "+jobDataDir+", where 'rhino run --server --dir' mounts the NFS directory")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.dataDir, "dir", "", "the same as --data-dir, e.g. the local mount of the NFS directory given to 'rhino run --dir'")
+	dockerRunCmd.MarkFlagsMutuallyExclusive("data-dir", "dir")
+	dockerRunCmd.Flags().StringVarP(&dockerRunOpts.workdir, "workdir", "w", "", "the working directory in the container")
 	dockerRunCmd.Flags().IntVar(&dockerRunOpts.parallel, "np", 1, "the number of MPI processes")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.mpi, "mpi", MPIOpenMPI, "the MPI implementation in the image, choose from [openmpi, mpich]")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.mapBy, "map-by", "", "how the MPI processes are mapped, as the --map-by of OpenMPI, e.g. slot, node, core, socket, translated for mpich")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.bindTo, "bind-to", "", "what the MPI processes are bound to, as the --bind-to of OpenMPI, e.g. none, hwthread, core, socket, translated for mpich")
+	dockerRunCmd.Flags().BoolVar(&dockerRunOpts.oversubscribe, "oversubscribe", false, "allow more MPI processes than CPUs")
+	dockerRunCmd.Flags().StringArrayVar(&dockerRunOpts.mpiArgs, "mpi-arg", nil, "an extra option passed to mpirun as is, e.g. --mpi-arg=--report-bindings, can be repeated")
+	dockerRunCmd.Flags().IntVar(&dockerRunOpts.threadsPerRank, "threads-per-rank", 0, "the number of OpenMP threads of each MPI process, which sets OMP_NUM_THREADS")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.name, "name", "", "the name of the run and of its container, generated if empty")
+	dockerRunCmd.Flags().BoolVar(&dockerRunOpts.remove, "rm", true, "remove the container when it exits, use --rm=false to keep it")
+	dockerRunCmd.Flags().BoolVarP(&dockerRunOpts.detach, "detach", "d", false, "run in the background, see 'rhino docker-ps', 'rhino docker-logs' and 'rhino docker-stop'")
+	dockerRunCmd.Flags().IntVar(&dockerRunOpts.nodes, "nodes", 1, "the number of containers, connected by a private network like the nodes of a cluster")
+	dockerRunCmd.Flags().IntVar(&dockerRunOpts.slots, "slots", 1, "the number of MPI processes of each container with --nodes")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.memoryAllocationMode, "mem-mode", FixedPerCoreMemory, "the memory allocation mode as in 'rhino run', choose from [FixedTotalMemory, FixedPerCoreMemory]")
+	dockerRunCmd.Flags().IntVar(&dockerRunOpts.memoryAllocationSize, "mem-size", 2, "the memory allocation size as in 'rhino run', in GB")
+	dockerRunCmd.Flags().Float64Var(&dockerRunOpts.cpus, "cpus", 0, "the number of CPUs of each container, no limit if 0")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.pull, "pull", PullMissing, "pull the image before running, choose from [always, missing, never]")
+	dockerRunCmd.Flags().BoolVar(&dockerRunOpts.stats, "stats", false, "print the wall time, the peak memory and the CPU usage of the run, and the suggested memory allocation")
+	dockerRunCmd.Flags().StringVar(&dockerRunOpts.statsCSV, "stats-csv", "", "write the memory and CPU usage sampled during the run to a CSV file, implies --stats")
+	dockerRunCmd.Flags().DurationVar(&dockerRunOpts.timeout, "timeout", 0, fmt.Sprintf("kill the run after this time and exit with code %d, like the TTL of 'rhino run', no limit if 0", timeoutExitCode))
+	dockerRunCmd.Flags().DurationVar(&dockerRunOpts.stopTimeout, "stop-timeout", 10*time.Second, "the time the program is given to exit after Ctrl-C before it is killed")
 
 	return dockerRunCmd
 }
@@ -50,8 +143,11 @@ func (r *DockerRunOptions) dockerRun(cmd *cobra.Command, args []string) error {
 		cmd.Help()
 		return nil
 	}
-	if r.parallel < 1 {
-		return fmt.Errorf("the number of MPI processes (--np) must be greater than 0")
+	if r.nodes > 1 && !cmd.Flags().Changed("np") {
+		r.parallel = r.nodes * r.slots
+	}
+	if err := r.validate(); err != nil {
+		return err
 	}
 
 	// Create a DockerHelper instance
@@ -61,23 +157,210 @@ func (r *DockerRunOptions) dockerRun(cmd *cobra.Command, args []string) error {
 	}
 
 	// Check if the image exists and pull it if necessary
-	err = helper.checkAndPullImage(args[0])
+	err = helper.checkAndPullImage(args[0], r.pull)
 	if err != nil {
 		return err
 	}
 
+	// Use the settings recorded by 'rhino build' in the image
+	r.executable = applyFunctionLabels(cmd, args[0], localImageLabels(args[0]), &r.mpi)
+
+	// The runs are found by their name
+	if r.name == "" {
+		if r.name, err = newRunName(); err != nil {
+			return err
+		}
+	}
+
+	if r.nodes > 1 {
+		return r.runCluster(helper, args)
+	}
+
 	// Create and start the container
 	containerID, err := helper.createAndStartContainer(r, args)
 	if err != nil {
 		return err
 	}
 
-	// Get the container logs
-	err = helper.getContainerLogs(containerID)
-	if err != nil {
+	if r.detach {
+		r.printDetached()
+		return nil
+	}
+	if r.remove {
+		defer helper.removeContainer(containerID)
+	} else {
+		defer fmt.Printf("Container %s kept, remove it with 'docker rm %s'\n", r.containerName(containerID), r.containerName(containerID))
+	}
+
+	// Print the container logs until it exits, and retrieve the exit status
+	return r.followWithStats(helper, containerID, map[string]string{containerID: r.name})
+}
+
+func (r *DockerRunOptions) validate() error {
+	if err := validateRunOptions(r.parallel, r.mpi); err != nil {
+		return err
+	}
+	if r.name != "" && !validContainerName.MatchString(r.name) {
+		return fmt.Errorf("invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", r.name)
+	}
+	if r.threadsPerRank < 0 {
+		return fmt.Errorf("the number of threads per rank (--threads-per-rank) cannot be negative")
+	}
+	if r.stopTimeout < 0 {
+		return fmt.Errorf("the grace period (--stop-timeout) cannot be negative")
+	}
+	if err := validatePullPolicy(r.pull); err != nil {
+		return err
+	}
+	if r.timeout < 0 {
+		return fmt.Errorf("the timeout (--timeout) cannot be negative")
+	}
+	if r.timeout > 0 && r.detach {
+		return fmt.Errorf("--timeout cannot be used with --detach")
+	}
+	if r.statsCSV != "" {
+		r.stats = true
+	}
+	if r.stats && r.detach {
+		return fmt.Errorf("--stats cannot be used with --detach")
+	}
+	if err := validateMemoryOptions(r.memoryAllocationMode, r.memoryAllocationSize); err != nil {
 		return err
 	}
+	if err := r.validateMounts(); err != nil {
+		return err
+	}
+	if r.cpus < 0 {
+		return fmt.Errorf("the number of CPUs (--cpus) cannot be negative")
+	}
+	if r.nodes < 1 || r.slots < 1 {
+		return fmt.Errorf("the number of nodes (--nodes) and of slots (--slots) must be greater than 0")
+	}
+	if r.nodes > 1 {
+		if r.parallel > r.nodes*r.slots {
+			return fmt.Errorf("the number of MPI processes (--np) cannot exceed %d nodes with %d slots each", r.nodes, r.slots)
+		}
+		if !r.remove {
+			return fmt.Errorf("--rm=false cannot be used with --nodes, the containers are always removed")
+		}
+	}
+	return nil
+}
+
+// launcherArgs returns the options given to mpirun, in the syntax of the MPI implementation of the image
+func (r *DockerRunOptions) launcherArgs() ([]string, error) {
+	args, err := mpirunPlacementArgs(r.mpi, r.mapBy, r.bindTo, r.oversubscribe)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, r.mpiArgs...), nil
+}
+
+// newRunName generates the name of a run
+func newRunName() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return "rhino-" + hex.EncodeToString(suffix), nil
+}
+
+// runLabels returns the labels of a container of the run with the given role
+func (r *DockerRunOptions) runLabels(role string) map[string]string {
+	if r.name == "" {
+		return nil
+	}
+	return map[string]string{
+		runLabel:     r.name,
+		runNPLabel:   strconv.Itoa(r.parallel),
+		runRoleLabel: role,
+	}
+}
+
+func (r *DockerRunOptions) printDetached() {
+	fmt.Printf("Run %s started, follow it with 'rhino docker-logs -f %s' and stop it with 'rhino docker-stop %s'\n", r.name, r.name, r.name)
+}
 
-	// Wait for the container to exit and retrieve the exit status
-	return helper.waitForContainerExit(containerID)
+// timedOut prints the last lines of the output of the container killed by the timeout
+func (r *DockerRunOptions) timedOut(helper *DockerHelper, containerID string) error {
+	fmt.Printf("\nRun %s killed after %v, the last %d lines of its output:\n", r.containerName(containerID), r.timeout, timeoutTailLines)
+	if err := helper.containerLogs(containerID, false, strconv.Itoa(timeoutTailLines)); err != nil {
+		fmt.Println("Warning: failed to read the output:", err)
+	}
+	return &exitCodeError{
+		err:  fmt.Errorf("the run %s timed out after %v", r.containerName(containerID), r.timeout),
+		code: timeoutExitCode,
+	}
+}
+
+// containerName returns the name of the container, or its short ID if it has no name given
+func (r *DockerRunOptions) containerName(containerID string) string {
+	if r.name != "" {
+		return r.name
+	}
+	if len(containerID) > 12 {
+		return containerID[:12]
+	}
+	return containerID
+}
+
+// followWithStats follows the container, and reports the resource usage of the given containers with --stats
+func (r *DockerRunOptions) followWithStats(helper *DockerHelper, containerID string, sampled map[string]string) error {
+	if !r.stats {
+		return r.follow(helper, containerID)
+	}
+	collector := helper.collectStats(sampled)
+	err := r.follow(helper, containerID)
+	if statsErr := r.reportStats(collector); statsErr != nil && err == nil {
+		return statsErr
+	}
+	return err
+}
+
+// follow prints the output of the container until it exits. SIGINT and SIGTERM stop the container,
+// which is killed if it does not exit within the grace period or on a second signal.
+func (r *DockerRunOptions) follow(helper *DockerHelper, containerID string) error {
+	signalCh := make(chan os.Signal, 2)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+
+	logsDone := make(chan error, 1)
+	go func() {
+		logsDone <- helper.getContainerLogs(containerID)
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- helper.waitForContainerExit(containerID, r.timeout)
+	}()
+
+	var interrupted os.Signal
+	for {
+		select {
+		case err := <-exited:
+			if logsErr := <-logsDone; err == nil {
+				err = logsErr
+			}
+			if interrupted != nil {
+				return fmt.Errorf("interrupted by %v, container %s stopped", interrupted, r.containerName(containerID))
+			}
+			var timeoutErr *containerTimeoutError
+			if errors.As(err, &timeoutErr) {
+				return r.timedOut(helper, containerID)
+			}
+			return err
+		case sig := <-signalCh:
+			if interrupted != nil {
+				fmt.Println("Killing container", r.containerName(containerID))
+				helper.killContainer(containerID)
+				continue
+			}
+			interrupted = sig
+			fmt.Printf("Stopping container %s, press Ctrl-C again to kill it\n", r.containerName(containerID))
+			go func() {
+				if err := helper.stopContainer(containerID, r.stopTimeout); err != nil {
+					fmt.Println("Warning: failed to stop the container:", err)
+				}
+			}()
+		}
+	}
 }
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+type EjectOptions struct {
+	force bool
+}
+
+func NewEjectCommand() *cobra.Command {
+	ejectOpts := &EjectOptions{}
+	ejectCmd := &cobra.Command{
+		Use:   "eject",
+		Short: "Write the Dockerfile generated by 'rhino build' into the project",
+		Long: "\nWrite the Dockerfile that 'rhino build' generates from rhino.yaml into the project, so that it can be customized." +
+			"\nOnce ejected, the Dockerfile is used as is and does not get the fixes of newer versions of rhino.",
+		Example: `  rhino eject
+  rhino eject --force`,
+		Args: cobra.NoArgs,
+		RunE: ejectOpts.runEject,
+	}
+	ejectCmd.Flags().BoolVar(&ejectOpts.force, "force", false, "overwrite the Dockerfile of the project")
+	return ejectCmd
+}
+
+func (e *EjectOptions) runEject(cmd *cobra.Command, args []string) error {
+	if _, err := os.Stat(projectDockerfile); err == nil && !e.force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", projectDockerfile)
+	}
+	manifest, err := loadManifest(".")
+	if err != nil {
+		return err
+	}
+	dockerfile, err := renderDockerfile(manifest, manifest.Build.BuilderImage, manifest.Build.RuntimeImage, nil)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(projectDockerfile, []byte(dockerfile), 0644); err != nil {
+		return err
+	}
+	fmt.Println(projectDockerfile, "written, 'rhino build' now uses it instead of the generated one")
+	return nil
+}
@@ -0,0 +1,432 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The C library and the dynamic loader always come from the runtime image
+var systemLibraryPatterns = []string{
+	"ld-linux*", "ld-musl-*", "libc.musl-*", "linux-vdso.so*",
+	"libc.so*", "libm.so*", "libdl.so*", "librt.so*", "libpthread.so*", "libresolv.so*", "libutil.so*",
+}
+
+// LibraryReport describes a shared library bundled into the function image
+type LibraryReport struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	RealPath string `json:"realPath"`
+	NeededBy string `json:"neededBy"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// DependencyReport is the result of the shared library analysis of a function executable
+type DependencyReport struct {
+	Executable       string          `json:"executable"`
+	ExecutableSize   int64           `json:"executableSize"`
+	ExecutableSHA256 string          `json:"executableSha256"`
+	Bundled          []LibraryReport `json:"bundled"`
+	Excluded         []string        `json:"excluded,omitempty"`
+	Missing          []string        `json:"missing,omitempty"`
+}
+
+// depsFS gives access to the filesystem the executable was built in
+type depsFS interface {
+	// realPath evaluates all the symlinks in name, it fails if name does not exist
+	realPath(name string) (string, error)
+	readFile(name string) ([]byte, error)
+	readDir(name string) ([]string, error)
+}
+
+// hostFS is the filesystem of the local machine
+type hostFS struct{}
+
+func (hostFS) realPath(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+func (hostFS) readFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (hostFS) readDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// containerFS is the filesystem of a (stopped) container
+type containerFS struct {
+	helper      *DockerHelper
+	containerID string
+}
+
+func (c *containerFS) realPath(name string) (string, error) {
+	stat, err := c.helper.cli.ContainerStatPath(c.helper.ctx, c.containerID, name)
+	if err != nil {
+		return "", err
+	}
+	if stat.Mode&os.ModeSymlink != 0 {
+		// The daemon has already evaluated the whole chain inside the container
+		return stat.LinkTarget, nil
+	}
+	return name, nil
+}
+
+func (c *containerFS) readFile(name string) ([]byte, error) {
+	reader, _, err := c.helper.cli.CopyFromContainer(c.helper.ctx, c.containerID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is not a regular file", name)
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+func (c *containerFS) readDir(name string) ([]string, error) {
+	reader, _, err := c.helper.cli.CopyFromContainer(c.helper.ctx, c.containerID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	names := []string{}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// The entries are prefixed by the base name of the directory
+		parts := strings.Split(strings.Trim(header.Name, "/"), "/")
+		if len(parts) == 2 {
+			names = append(names, parts[1])
+		}
+	}
+}
+
+// findExecutable looks for the only executable named funcName in a tar stream of dir
+func findExecutable(archive io.Reader, dir string, funcName string) (string, error) {
+	found := []string{}
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == funcName && header.Mode&0111 != 0 {
+			// The entries are prefixed by the base name of the directory
+			found = append(found, path.Join(path.Dir(dir), header.Name))
+		}
+	}
+	return pickExecutable(found, funcName)
+}
+
+// pickExecutable checks that exactly one executable has been found
+func pickExecutable(found []string, funcName string) (string, error) {
+	if len(found) == 0 {
+		return "", fmt.Errorf("cannot find the executable file %s. Please check your Makefile", funcName)
+	} else if len(found) > 1 {
+		return "", fmt.Errorf("found multiple executable files named %s: %s. Please check your Makefile", funcName, strings.Join(found, ", "))
+	}
+	return found[0], nil
+}
+
+// dependencyAnalyzer resolves the shared libraries of an executable the same way as the dynamic loader
+type dependencyAnalyzer struct {
+	fs          depsFS
+	include     []string
+	exclude     []string
+	searchPaths []string
+	// contents of the bundled libraries, keyed by name
+	contents map[string][]byte
+}
+
+func newDependencyAnalyzer(fs depsFS, manifest *ProjectManifest, mpi string) *dependencyAnalyzer {
+	exclude := append([]string{}, systemLibraryPatterns...)
+	exclude = append(exclude, mpiLibraryPatterns(mpi)...)
+	exclude = append(exclude, manifest.Libraries.Exclude...)
+	return &dependencyAnalyzer{
+		fs:          fs,
+		include:     manifest.Libraries.Include,
+		exclude:     exclude,
+		searchPaths: manifest.Libraries.SearchPaths,
+		contents:    map[string][]byte{},
+	}
+}
+
+type elfObject struct {
+	path     string
+	realPath string
+	needed   []string
+	rpath    []string
+	runpath  []string
+	machine  elf.Machine
+	class    elf.Class
+}
+
+func (a *dependencyAnalyzer) loadObject(name string) (*elfObject, []byte, error) {
+	realPath, err := a.fs.realPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := a.fs.readFile(realPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is not an ELF file: %v", name, err)
+	}
+	defer f.Close()
+
+	obj := &elfObject{path: name, realPath: realPath, machine: f.Machine, class: f.Class}
+	// Statically linked files have no dynamic section
+	if obj.needed, err = f.ImportedLibraries(); err != nil {
+		obj.needed = nil
+	}
+	origin := path.Dir(realPath)
+	for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+		values, _ := f.DynString(tag)
+		dirs := []string{}
+		for _, value := range values {
+			for _, dir := range strings.Split(value, ":") {
+				if dir == "" {
+					continue
+				}
+				dir = strings.ReplaceAll(dir, "${ORIGIN}", origin)
+				dir = strings.ReplaceAll(dir, "$ORIGIN", origin)
+				dirs = append(dirs, dir)
+			}
+		}
+		if tag == elf.DT_RPATH {
+			obj.rpath = dirs
+		} else {
+			obj.runpath = dirs
+		}
+	}
+	return obj, data, nil
+}
+
+// defaultLibraryDirs returns the directories configured for the loader followed by the built-in ones
+func (a *dependencyAnalyzer) defaultLibraryDirs(machine elf.Machine, class elf.Class) []string {
+	dirs := []string{}
+	// musl reads /etc/ld-musl-$ARCH.path, glibc reads /etc/ld.so.conf
+	arch, triplet := "x86_64", "x86_64-linux-gnu"
+	if machine == elf.EM_AARCH64 {
+		arch, triplet = "aarch64", "aarch64-linux-gnu"
+	}
+	if data, err := a.fs.readFile("/etc/ld-musl-" + arch + ".path"); err == nil {
+		dirs = append(dirs, strings.FieldsFunc(string(data), func(r rune) bool { return r == ':' || r == '\n' })...)
+	}
+	dirs = append(dirs, a.readLdSoConf("/etc/ld.so.conf", 0)...)
+
+	if class == elf.ELFCLASS64 {
+		dirs = append(dirs, "/lib64", "/usr/lib64")
+	}
+	return append(dirs, "/lib/"+triplet, "/usr/lib/"+triplet, "/lib", "/usr/lib", "/usr/local/lib")
+}
+
+func (a *dependencyAnalyzer) readLdSoConf(name string, depth int) []string {
+	data, err := a.fs.readFile(name)
+	if err != nil || depth > 4 {
+		return nil
+	}
+	dirs := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.SplitN(line, "#", 2)[0])
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "include ") {
+			dirs = append(dirs, line)
+			continue
+		}
+		pattern := strings.TrimSpace(strings.TrimPrefix(line, "include "))
+		if !path.IsAbs(pattern) {
+			pattern = path.Join(path.Dir(name), pattern)
+		}
+		entries, err := a.fs.readDir(path.Dir(pattern))
+		if err != nil {
+			continue
+		}
+		sort.Strings(entries)
+		for _, entry := range entries {
+			if matched, _ := path.Match(path.Base(pattern), entry); matched {
+				dirs = append(dirs, a.readLdSoConf(path.Join(path.Dir(pattern), entry), depth+1)...)
+			}
+		}
+	}
+	return dirs
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// analyze walks the dependency graph of the executable and returns the libraries to bundle
+func (a *dependencyAnalyzer) analyze(executable string) (*DependencyReport, error) {
+	report := &DependencyReport{Executable: executable, Bundled: []LibraryReport{}}
+	exec, execData, err := a.loadObject(executable)
+	if err != nil {
+		return nil, err
+	}
+	execSum := sha256.Sum256(execData)
+	report.ExecutableSize = int64(len(execData))
+	report.ExecutableSHA256 = hex.EncodeToString(execSum[:])
+	defaultDirs := a.defaultLibraryDirs(exec.machine, exec.class)
+	seen := map[string]bool{}
+
+	queue := []*elfObject{exec}
+	// Absolute paths in the include list are libraries loaded at runtime, e.g. with dlopen
+	for _, include := range a.include {
+		if !path.IsAbs(include) {
+			continue
+		}
+		name := path.Base(include)
+		seen[name] = true
+		obj, err := a.bundle(report, name, include, "manifest")
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, obj)
+	}
+
+	for len(queue) > 0 {
+		obj := queue[0]
+		queue = queue[1:]
+		for _, needed := range obj.needed {
+			name := path.Base(needed)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if matchAny(a.exclude, name) && !matchAny(a.include, name) {
+				report.Excluded = append(report.Excluded, name)
+				continue
+			}
+
+			found := ""
+			if strings.Contains(needed, "/") {
+				found = needed
+			} else {
+				// RPATH is ignored when RUNPATH is present, and is inherited from the executable
+				dirs := []string{}
+				if len(obj.runpath) == 0 {
+					dirs = append(dirs, obj.rpath...)
+					if obj != exec {
+						dirs = append(dirs, exec.rpath...)
+					}
+				}
+				dirs = append(dirs, a.searchPaths...)
+				dirs = append(dirs, obj.runpath...)
+				dirs = append(dirs, defaultDirs...)
+				for _, dir := range dirs {
+					candidate := path.Join(dir, needed)
+					if _, err := a.fs.realPath(candidate); err == nil {
+						found = candidate
+						break
+					}
+				}
+			}
+			if found == "" {
+				report.Missing = append(report.Missing, name)
+				continue
+			}
+			lib, err := a.bundle(report, name, found, obj.path)
+			if err != nil {
+				return nil, err
+			}
+			queue = append(queue, lib)
+		}
+	}
+	if len(report.Missing) > 0 {
+		return report, fmt.Errorf("cannot find the shared libraries: %s. Please add their directories to libraries.searchPaths in %s",
+			strings.Join(report.Missing, ", "), manifestFileName)
+	}
+	return report, nil
+}
+
+func (a *dependencyAnalyzer) bundle(report *DependencyReport, name string, libPath string, neededBy string) (*elfObject, error) {
+	obj, data, err := a.loadObject(libPath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	report.Bundled = append(report.Bundled, LibraryReport{
+		Name:     name,
+		Path:     libPath,
+		RealPath: obj.realPath,
+		NeededBy: neededBy,
+		Size:     int64(len(data)),
+		SHA256:   hex.EncodeToString(sum[:]),
+	})
+	a.contents[name] = data
+	return obj, nil
+}
+
+// writeBundle copies the bundled libraries into dir, named as the loader looks for them
+func (a *dependencyAnalyzer) writeBundle(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, data := range a.contents {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
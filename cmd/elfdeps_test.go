@@ -0,0 +1,92 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestArchive(t *testing.T, files map[string]int64) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, mode := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Typeflag: tar.TypeReg})
+		assert.Equal(t, nil, err, "write test archive failed: %s", errorMessage(err))
+	}
+	tw.Close()
+	return &buf
+}
+
+func TestFindExecutable(t *testing.T) {
+	archive := newTestArchive(t, map[string]int64{
+		"app/src/main.cpp": 0644,
+		"app/src/main.o":   0644,
+		"app/src/mpi-func": 0755,
+	})
+	executable, err := findExecutable(archive, "/app", "mpi-func")
+	assert.Equal(t, nil, err, "test find executable failed: %s", errorMessage(err))
+	assert.Equal(t, "/app/src/mpi-func", executable)
+
+	// a non executable file is not the function
+	archive = newTestArchive(t, map[string]int64{"app/mpi-func": 0644})
+	_, err = findExecutable(archive, "/app", "mpi-func")
+	assert.Equal(t, fmt.Errorf("cannot find the executable file mpi-func. Please check your Makefile"), err)
+
+	archive = newTestArchive(t, map[string]int64{"app/a/mpi-func": 0755, "app/b/mpi-func": 0755})
+	_, err = findExecutable(archive, "/app", "mpi-func")
+	assert.Equal(t, true, err != nil && strings.HasPrefix(err.Error(), "found multiple executable files"), "test failed: duplicate executables not reported")
+}
+
+// analyze an executable of the machine running the test, the C library must never be bundled
+func TestAnalyzeDependencies(t *testing.T) {
+	manifest := &ProjectManifest{Libraries: LibrariesSpec{Exclude: []string{"libnotused.so"}}}
+	analyzer := newDependencyAnalyzer(hostFS{}, manifest, MPIOpenMPI)
+	report, err := analyzer.analyze("/bin/sh")
+	assert.Equal(t, nil, err, "test analyze failed: %s", errorMessage(err))
+	assert.Equal(t, "/bin/sh", report.Executable)
+	for _, lib := range report.Bundled {
+		assert.Equal(t, false, matchAny(systemLibraryPatterns, lib.Name), "test failed: system library %s bundled", lib.Name)
+		assert.Equal(t, lib.Size, int64(len(analyzer.contents[lib.Name])))
+	}
+
+	// explicitly included libraries are bundled even if they are system libraries
+	manifest = &ProjectManifest{Libraries: LibrariesSpec{Include: []string{"libc.so*"}}}
+	analyzer = newDependencyAnalyzer(hostFS{}, manifest, MPIOpenMPI)
+	report, err = analyzer.analyze("/bin/sh")
+	assert.Equal(t, nil, err, "test analyze failed: %s", errorMessage(err))
+	bundledLibc := false
+	for _, lib := range report.Bundled {
+		if strings.HasPrefix(lib.Name, "libc.so") {
+			bundledLibc = true
+		}
+	}
+	assert.Equal(t, true, bundledLibc, "test failed: included library not bundled")
+}
+
+func TestMatchLibraryPatterns(t *testing.T) {
+	assert.Equal(t, true, matchAny(mpiLibraryPatterns(MPIOpenMPI), "libmpi.so.40"))
+	assert.Equal(t, true, matchAny(mpiLibraryPatterns(MPIMPICH), "libmpich.so.12"))
+	// libraries whose names only contain "mpi" are not MPI libraries
+	assert.Equal(t, false, matchAny(mpiLibraryPatterns(MPIOpenMPI), "libcompiler_rt.so"))
+	assert.Equal(t, false, matchAny(mpiLibraryPatterns(MPIOpenMPI), "libimpifft.so.1"))
+	assert.Equal(t, true, matchAny(systemLibraryPatterns, "ld-musl-x86_64.so.1"))
+}
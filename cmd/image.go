@@ -0,0 +1,329 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func NewImageCommand() *cobra.Command {
+	imageCmd := &cobra.Command{
+		Use:   "image",
+		Short: "Inspect function images",
+		Long:  "\nInspect the function images built by 'rhino build'",
+	}
+	imageCmd.AddCommand(NewImageInspectCommand())
+	imageCmd.AddCommand(NewImageSBOMCommand())
+	return imageCmd
+}
+
+type ImageInspectOptions struct {
+	json bool
+}
+
+// ImageReport describes the content of a function image
+type ImageReport struct {
+	Image        string            `json:"image"`
+	ID           string            `json:"id"`
+	Created      string            `json:"created"`
+	Size         int64             `json:"size"`
+	Labels       map[string]string `json:"labels"`
+	Executable   ImageFile         `json:"executable"`
+	Libraries    []ImageFile       `json:"libraries"`
+	BuilderImage string            `json:"builderImage"`
+	RuntimeImage string            `json:"runtimeImage"`
+	Layers       []ImageLayer      `json:"layers"`
+}
+
+type ImageFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// The target of a symlink
+	LinkTarget string `json:"linkTarget,omitempty"`
+}
+
+type ImageLayer struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"createdBy"`
+	Size      int64  `json:"size"`
+}
+
+func NewImageInspectCommand() *cobra.Command {
+	inspectOpts := &ImageInspectOptions{}
+	inspectCmd := &cobra.Command{
+		Use:   "inspect [image]",
+		Short: "Show the content of a function image",
+		Long:  "\nShow the labels, the executable, the bundled shared libraries, the layers and the base images of a function image",
+		Example: `  rhino image inspect foo/hello:v1.0
+  rhino image inspect foo/hello:v1.0 --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: inspectOpts.runInspect,
+	}
+	inspectCmd.Flags().BoolVar(&inspectOpts.json, "json", false, "print the result in JSON")
+	return inspectCmd
+}
+
+func (i *ImageInspectOptions) runInspect(cmd *cobra.Command, args []string) error {
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return err
+	}
+	if err := helper.checkAndPullImage(args[0], PullMissing); err != nil {
+		return err
+	}
+	report, err := helper.inspectFunctionImage(args[0])
+	if err != nil {
+		return err
+	}
+	if i.json {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return printImageReport(os.Stdout, report)
+}
+
+// inspectFunctionImage collects the metadata of image and looks into its filesystem
+func (dh *DockerHelper) inspectFunctionImage(image string) (*ImageReport, error) {
+	inspect, _, err := dh.cli.ImageInspectWithRaw(dh.ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImageReport{
+		Image:     image,
+		ID:        inspect.ID,
+		Created:   inspect.Created,
+		Size:      inspect.Size,
+		Labels:    map[string]string{},
+		Libraries: []ImageFile{},
+		Layers:    []ImageLayer{},
+	}
+	labels := map[string]string{}
+	if inspect.Config != nil && inspect.Config.Labels != nil {
+		labels = inspect.Config.Labels
+	}
+	for key, value := range labels {
+		// The library report and the SBOM are printed by their own commands
+		if key != libraryReportLabel && key != sbomLabel {
+			report.Labels[key] = value
+		}
+	}
+	report.BuilderImage = labels[builderImageLabel]
+	report.RuntimeImage = labels[ociBaseNameLabel]
+
+	history, err := dh.cli.ImageHistory(dh.ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	// The history starts from the newest layer
+	for j := len(history) - 1; j >= 0; j-- {
+		report.Layers = append(report.Layers, ImageLayer{ID: history[j].ID, CreatedBy: history[j].CreatedBy, Size: history[j].Size})
+	}
+
+	containerID, err := dh.createContainer(image)
+	if err != nil {
+		return nil, err
+	}
+	defer dh.removeContainer(containerID)
+
+	report.Executable.Path = defaultFuncExecutable
+	if labels[executableLabel] != "" {
+		report.Executable.Path = labels[executableLabel]
+	}
+	fs := &containerFS{helper: dh, containerID: containerID}
+	realPath, err := fs.realPath(report.Executable.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the executable %s in %s: %v", report.Executable.Path, image, err)
+	}
+	stat, err := dh.cli.ContainerStatPath(dh.ctx, containerID, realPath)
+	if err != nil {
+		return nil, err
+	}
+	report.Executable.Size = stat.Size
+
+	// The libraries rhino build bundled are in its report, the directory also has the files of the runtime image
+	if libraries, ok := bundledLibraries(labels); ok {
+		report.Libraries = libraries
+		return report, nil
+	}
+	archive, err := dh.copyFromContainer(containerID, bundledLibraryDir)
+	if err != nil {
+		// No shared library is bundled
+		return report, nil
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink {
+			continue
+		}
+		report.Libraries = append(report.Libraries, ImageFile{
+			Path:       path.Join(path.Dir(bundledLibraryDir), header.Name),
+			Size:       header.Size,
+			LinkTarget: header.Linkname,
+		})
+	}
+	return report, nil
+}
+
+// bundledLibraries returns the shared libraries bundled into the image by the library report in its labels,
+// false if the image was built without it
+func bundledLibraries(labels map[string]string) ([]ImageFile, bool) {
+	deps := DependencyReport{}
+	if labels[libraryReportLabel] == "" || json.Unmarshal([]byte(labels[libraryReportLabel]), &deps) != nil {
+		return nil, false
+	}
+	libraries := []ImageFile{}
+	for _, lib := range deps.Bundled {
+		libraries = append(libraries, ImageFile{Path: path.Join(bundledLibraryDir, lib.Name), Size: lib.Size})
+	}
+	return libraries, true
+}
+
+func printImageReport(w io.Writer, report *ImageReport) error {
+	tw := tabwriter.NewWriter(w, 2, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Image:\t%s\n", report.Image)
+	fmt.Fprintf(tw, "ID:\t%s\n", report.ID)
+	fmt.Fprintf(tw, "Created:\t%s\n", report.Created)
+	fmt.Fprintf(tw, "Size:\t%s\n", formatSize(report.Size))
+	fmt.Fprintf(tw, "Builder image:\t%s\n", valueOrUnknown(report.BuilderImage))
+	fmt.Fprintf(tw, "Runtime image:\t%s\n", valueOrUnknown(report.RuntimeImage))
+	fmt.Fprintf(tw, "Executable:\t%s (%s)\n", report.Executable.Path, formatSize(report.Executable.Size))
+
+	fmt.Fprintln(tw, "\nLabels:")
+	keys := []string{}
+	for key := range report.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(tw, "  %s\t%s\n", key, report.Labels[key])
+	}
+
+	fmt.Fprintf(tw, "\nShared libraries in %s:\n", bundledLibraryDir)
+	if len(report.Libraries) == 0 {
+		fmt.Fprintln(tw, "  (none)")
+	}
+	for _, lib := range report.Libraries {
+		if lib.LinkTarget != "" {
+			fmt.Fprintf(tw, "  %s\t-> %s\n", lib.Path, lib.LinkTarget)
+		} else {
+			fmt.Fprintf(tw, "  %s\t%s\n", lib.Path, formatSize(lib.Size))
+		}
+	}
+
+	fmt.Fprintln(tw, "\nLayers:")
+	for _, layer := range report.Layers {
+		createdBy := layer.CreatedBy
+		if len(createdBy) > 80 {
+			createdBy = createdBy[:77] + "..."
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", formatSize(layer.Size), createdBy)
+	}
+	return tw.Flush()
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// formatSize prints a size in bytes with a binary unit, e.g. 1.5MiB
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+type ImageSBOMOptions struct {
+	output string
+}
+
+func NewImageSBOMCommand() *cobra.Command {
+	sbomOpts := &ImageSBOMOptions{}
+	sbomCmd := &cobra.Command{
+		Use:   "sbom [image]",
+		Short: "Print the SBOM of a function image",
+		Long:  "\nPrint the software bill of materials (SPDX JSON) recorded by 'rhino build' in a function image",
+		Example: `  rhino image sbom foo/hello:v1.0
+  rhino image sbom foo/hello:v1.0 -o hello.spdx.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: sbomOpts.runSBOM,
+	}
+	sbomCmd.Flags().StringVarP(&sbomOpts.output, "output", "o", "", "write the SBOM to a file instead of stdout")
+	return sbomCmd
+}
+
+func (s *ImageSBOMOptions) runSBOM(cmd *cobra.Command, args []string) error {
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return err
+	}
+	if err := helper.checkAndPullImage(args[0], PullMissing); err != nil {
+		return err
+	}
+	inspect, _, err := helper.cli.ImageInspectWithRaw(helper.ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	var sbom []byte
+	if inspect.Config != nil && inspect.Config.Labels[sbomLabel] != "" {
+		sbom = []byte(inspect.Config.Labels[sbomLabel])
+	} else if sbom, err = helper.readImageFile(args[0], sbomImagePath); err != nil {
+		return fmt.Errorf("no SBOM found in %s, please rebuild it with 'rhino build'", args[0])
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, sbom, "", "  "); err != nil {
+		return fmt.Errorf("invalid SBOM in %s: %v", args[0], err)
+	}
+	out.WriteString("\n")
+	if len(s.output) > 0 {
+		return os.WriteFile(s.output, out.Bytes(), 0644)
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
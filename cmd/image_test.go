@@ -0,0 +1,61 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512B", formatSize(512))
+	assert.Equal(t, "1.5KiB", formatSize(1536))
+	assert.Equal(t, "2.0GiB", formatSize(2<<30))
+}
+
+func TestPrintImageReport(t *testing.T) {
+	report := &ImageReport{
+		Image:        "foo/hello:v1.0",
+		Size:         10 << 20,
+		Labels:       map[string]string{mpiLabel: MPIOpenMPI},
+		Executable:   ImageFile{Path: defaultFuncExecutable, Size: 2048},
+		Libraries:    []ImageFile{{Path: "/usr/local/lib/libfoo.so.1", Size: 4096}},
+		RuntimeImage: defaultRuntimeImage,
+		Layers:       []ImageLayer{{CreatedBy: "/bin/sh -c #(nop) CMD [\"/bin/sh\"]", Size: 0}},
+	}
+	var out bytes.Buffer
+	err := printImageReport(&out, report)
+	assert.Equal(t, nil, err, "test print image report failed: %s", errorMessage(err))
+
+	output := out.String()
+	for _, expected := range []string{"/app/mpi-func (2.0KiB)", "Builder image:  unknown", defaultRuntimeImage, "/usr/local/lib/libfoo.so.1  4.0KiB", mpiLabel} {
+		assert.Equal(t, true, strings.Contains(output, expected), "output does not contain %s:\n%s", expected, output)
+	}
+}
+
+func TestBundledLibraries(t *testing.T) {
+	labels := map[string]string{libraryReportLabel: `{"executable":"/app/src/mpi-func","bundled":[{"name":"libfoo.so.1","realPath":"/usr/lib/libfoo.so.1.2","size":4096}]}`}
+	libraries, ok := bundledLibraries(labels)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []ImageFile{{Path: "/usr/local/lib/libfoo.so.1", Size: 4096}}, libraries)
+
+	// images built without the report are inspected from their filesystem
+	_, ok = bundledLibraries(map[string]string{mpiLabel: MPIOpenMPI})
+	assert.Equal(t, false, ok)
+}
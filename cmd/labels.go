@@ -0,0 +1,223 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Labels added to the function images by 'rhino build'
+const (
+	executableLabel     = "org.openrhino.function.executable"
+	makefileLabel       = "org.openrhino.function.makefile"
+	makeArgsLabel       = "org.openrhino.function.make-args"
+	buildSystemLabel    = "org.openrhino.function.build-system"
+	variantLabel        = "org.openrhino.function.variant"
+	gitCommitLabel      = "org.openrhino.function.git-commit"
+	gitDirtyLabel       = "org.openrhino.function.git-dirty"
+	mpiLabel            = "org.openrhino.function.mpi"
+	builderImageLabel   = "org.openrhino.function.builder-image"
+	cliVersionLabel     = "org.openrhino.function.cli-version"
+	libraryReportLabel  = "org.openrhino.function.libraries"
+	sbomLabel           = "org.openrhino.function.sbom"
+	ociCreatedLabel     = "org.opencontainers.image.created"
+	ociTitleLabel       = "org.opencontainers.image.title"
+	ociVersionLabel     = "org.opencontainers.image.version"
+	ociRevisionLabel    = "org.opencontainers.image.revision"
+	ociSourceLabel      = "org.opencontainers.image.source"
+	ociBaseNameLabel    = "org.opencontainers.image.base.name"
+	ociBaseDigestLabel  = "org.opencontainers.image.base.digest"
+	ociDescriptionLabel = "org.opencontainers.image.description"
+)
+
+// Labels added to the containers of 'rhino docker-run', to find the runs and their containers
+const (
+	runLabel     = "org.openrhino.run"
+	runNPLabel   = "org.openrhino.run.np"
+	runRoleLabel = "org.openrhino.run.role"
+)
+
+// The roles of the containers of a run: a single container, or the launcher and the workers of --nodes
+const (
+	runRoleSingle   = "single"
+	runRoleLauncher = "launcher"
+	runRoleWorker   = "worker"
+)
+
+// buildProvenance records where a function image comes from
+type buildProvenance struct {
+	image              string
+	executable         string
+	mpi                string
+	buildSystem        string
+	variant            string
+	builderImage       string
+	runtimeImage       string
+	runtimeImageDigest string
+	gitCommit          string
+	gitDirty           bool
+	gitSource          string
+	makefile           string
+	makeArgs           string
+	created            time.Time
+	// the time of the sources, zero if unknown, which makes the SBOM in the image reproducible
+	sourceDate time.Time
+}
+
+// getGitSource returns the URL of the origin remote of the current git repository
+func getGitSource() string {
+	out, err := exec.Command("git", "config", "--get", "remote.origin.url").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// getSourceDate returns the time given by SOURCE_DATE_EPOCH, or the time of the commit
+func getSourceDate(commit string) time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if seconds, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(seconds, 0)
+		}
+	}
+	if commit == "" {
+		return time.Time{}
+	}
+	out, err := exec.Command("git", "show", "-s", "--format=%ct", commit).Output()
+	if err != nil {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func imageTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// labels returns the OCI and RHINO labels describing the image
+func (p *buildProvenance) labels() map[string]string {
+	labels := map[string]string{
+		ociCreatedLabel:     p.created.UTC().Format(time.RFC3339),
+		ociTitleLabel:       getFuncName(p.image),
+		ociVersionLabel:     imageTag(p.image),
+		ociDescriptionLabel: "RHINO function built by rhino " + RHINOCLIENTVERSION,
+		executableLabel:     p.executable,
+		makefileLabel:       p.makefile,
+		makeArgsLabel:       p.makeArgs,
+		mpiLabel:            p.mpi,
+		cliVersionLabel:     RHINOCLIENTVERSION,
+	}
+	if p.gitCommit != "" {
+		labels[ociRevisionLabel] = p.gitCommit
+		labels[gitCommitLabel] = p.gitCommit
+		labels[gitDirtyLabel] = strconv.FormatBool(p.gitDirty)
+	}
+	if p.buildSystem != "" {
+		labels[buildSystemLabel] = p.buildSystem
+	}
+	if p.variant != "" {
+		labels[variantLabel] = p.variant
+	}
+	if p.gitSource != "" {
+		labels[ociSourceLabel] = p.gitSource
+	}
+	if p.runtimeImage != "" {
+		labels[ociBaseNameLabel] = p.runtimeImage
+	}
+	if strings.HasPrefix(p.runtimeImageDigest, "sha256:") {
+		labels[ociBaseDigestLabel] = p.runtimeImageDigest
+	}
+	if p.builderImage != "" {
+		labels[builderImageLabel] = p.builderImage
+	}
+	return labels
+}
+
+// labelArgs turns labels into 'docker build' arguments, in a stable order
+func labelArgs(labels map[string]string) []string {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := []string{}
+	for _, key := range keys {
+		args = append(args, "--label", key+"="+labels[key])
+	}
+	return args
+}
+
+// localImageLabels returns the labels of an image present locally, or nil if docker or the image is not available
+func localImageLabels(image string) map[string]string {
+	helper, err := NewDockerHelper()
+	if err != nil {
+		return nil
+	}
+	inspect, _, err := helper.cli.ImageInspectWithRaw(helper.ctx, image)
+	if err != nil || inspect.Config == nil {
+		return nil
+	}
+	return inspect.Config.Labels
+}
+
+// imageWarnings checks the labels of a function image and returns what users should know before running it
+func imageWarnings(image string, labels map[string]string) []string {
+	warnings := []string{}
+	if labels[cliVersionLabel] == "" {
+		return append(warnings, fmt.Sprintf("%s was not built by 'rhino build', the default settings are used", image))
+	}
+	if labels[gitDirtyLabel] == "true" {
+		warnings = append(warnings, fmt.Sprintf("%s was built from uncommitted changes on top of commit %s", image, labels[gitCommitLabel]))
+	}
+	if labels[cliVersionLabel] != RHINOCLIENTVERSION {
+		warnings = append(warnings, fmt.Sprintf("%s was built by rhino %s, the current version is %s", image, labels[cliVersionLabel], RHINOCLIENTVERSION))
+	}
+	return warnings
+}
+
+// applyFunctionLabels takes the MPI implementation from the labels of a function, unless it is given
+// on the command line, prints the warnings and returns the path of the executable
+func applyFunctionLabels(cmd *cobra.Command, name string, labels map[string]string, mpi *string) string {
+	if flavor := labels[mpiLabel]; validateMPIFlavor(flavor) == nil {
+		if !cmd.Flags().Changed("mpi") {
+			*mpi = flavor
+		} else if flavor != *mpi {
+			fmt.Printf("Warning: %s was built with %s, but --mpi %s is given\n", name, flavor, *mpi)
+		}
+	}
+	for _, warning := range imageWarnings(name, labels) {
+		fmt.Println("Warning:", warning)
+	}
+	if labels[executableLabel] != "" {
+		return labels[executableLabel]
+	}
+	return defaultFuncExecutable
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvenanceLabels(t *testing.T) {
+	prov := &buildProvenance{
+		image:        "foo/hello:v1.0",
+		executable:   defaultFuncExecutable,
+		mpi:          MPIOpenMPI,
+		runtimeImage: defaultRuntimeImage,
+		gitCommit:    "0123456789abcdef",
+		gitDirty:     true,
+		makefile:     "./src/Makefile",
+		makeArgs:     "-j4",
+		created:      time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC),
+	}
+	labels := prov.labels()
+	assert.Equal(t, "2023-06-01T08:00:00Z", labels[ociCreatedLabel])
+	assert.Equal(t, "hello", labels[ociTitleLabel])
+	assert.Equal(t, "v1.0", labels[ociVersionLabel])
+	assert.Equal(t, "0123456789abcdef", labels[ociRevisionLabel])
+	assert.Equal(t, defaultRuntimeImage, labels[ociBaseNameLabel])
+	assert.Equal(t, "/app/mpi-func", labels[executableLabel])
+	assert.Equal(t, "-j4", labels[makeArgsLabel])
+	assert.Equal(t, "true", labels[gitDirtyLabel])
+	assert.Equal(t, RHINOCLIENTVERSION, labels[cliVersionLabel])
+
+	// projects outside of git have no revision
+	prov.gitCommit = ""
+	labels = prov.labels()
+	_, found := labels[ociRevisionLabel]
+	assert.Equal(t, false, found)
+
+	assert.Equal(t, []string{"--label", "a=1", "--label", "b=x y"}, labelArgs(map[string]string{"b": "x y", "a": "1"}))
+}
+
+func TestImageWarnings(t *testing.T) {
+	assert.Equal(t, []string{"foo:v1 was not built by 'rhino build', the default settings are used"}, imageWarnings("foo:v1", nil))
+	assert.Equal(t, []string{}, imageWarnings("foo:v1", map[string]string{cliVersionLabel: RHINOCLIENTVERSION, gitDirtyLabel: "false"}))
+
+	warnings := imageWarnings("foo:v1", map[string]string{cliVersionLabel: "v0.1.0", gitDirtyLabel: "true", gitCommitLabel: "abc"})
+	assert.Equal(t, []string{
+		"foo:v1 was built from uncommitted changes on top of commit abc",
+		"foo:v1 was built by rhino v0.1.0, the current version is " + RHINOCLIENTVERSION,
+	}, warnings)
+}
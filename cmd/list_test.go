@@ -36,6 +36,7 @@ func TestListSingleJob(t *testing.T) {
 	rootCmd := NewRootCommand()
 	// use `rhino build` to build template
 	os.Chdir("templates/func")
+	cleanBuildOutputs(t)
 	testFuncName := "test-list-func-cpp"
 	testFuncImageName := "test-list-func-cpp:v1"
 	rootCmd.SetArgs([]string{"build", "--image", testFuncImageName})
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+type LocalRunOptions struct {
+	parallel int
+	mpi      string
+}
+
+func NewLocalRunCommand() *cobra.Command {
+	localRunOpts := &LocalRunOptions{}
+	localRunCmd := &cobra.Command{
+		Use:   "local-run",
+		Short: "Run an MPI program built by 'rhino build --local'",
+		Long:  "\nRun the MPI program built by 'rhino build --local' with the MPI installed on this machine",
+		Example: `  rhino local-run
+  rhino local-run --np 4 -- arg1 arg2`,
+		RunE: localRunOpts.localRun,
+	}
+
+	localRunCmd.Flags().IntVar(&localRunOpts.parallel, "np", 1, "the number of MPI processes")
+	localRunCmd.Flags().StringVar(&localRunOpts.mpi, "mpi", MPIOpenMPI, "the MPI implementation on this machine, choose from [openmpi, mpich]")
+
+	return localRunCmd
+}
+
+func (r *LocalRunOptions) localRun(cmd *cobra.Command, args []string) error {
+	runCmd, err := r.command(cmd, args)
+	if err != nil {
+		return err
+	}
+	runCmd.Stdin = os.Stdin
+	return exitStatus(runCmd.Run())
+}
+
+// command returns the mpirun command running the local build with args
+func (r *LocalRunOptions) command(cmd *cobra.Command, args []string) (*exec.Cmd, error) {
+	if err := validateRunOptions(r.parallel, r.mpi); err != nil {
+		return nil, err
+	}
+	labels, err := readLocalLabels()
+	if err != nil {
+		return nil, err
+	}
+	executable, err := filepath.Abs(applyFunctionLabels(cmd, labels[executableLabel], labels, &r.mpi))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := loadManifest(".")
+	if err != nil {
+		return nil, err
+	}
+
+	mpirun := append(mpirunCommand(r.mpi, r.parallel, executable), args...)
+	if _, err := exec.LookPath(mpirun[0]); err != nil {
+		return nil, fmt.Errorf("%s not found in PATH, please make sure %s is installed on this machine", mpirun[0], r.mpi)
+	}
+	runCmd := exec.Command(mpirun[0], mpirun[1:]...)
+	runCmd.Env = append(os.Environ(), mpiEnv(r.mpi)...)
+	if paths := manifest.Libraries.SearchPaths; len(paths) > 0 {
+		libraryPath := strings.Join(paths, string(os.PathListSeparator))
+		if old := os.Getenv("LD_LIBRARY_PATH"); old != "" {
+			libraryPath += string(os.PathListSeparator) + old
+		}
+		runCmd.Env = append(runCmd.Env, "LD_LIBRARY_PATH="+libraryPath)
+	}
+	runCmd.Stdout = os.Stdout
+	runCmd.Stderr = os.Stderr
+	return runCmd, nil
+}
+
+// exitStatus turns the error of a finished program into the message used by the run commands
+func exitStatus(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("program exited with non-zero status: %d", exitErr.ExitCode())
+	}
+	return err
+}
+
+// readLocalLabels reads the labels written by 'rhino build --local'
+func readLocalLabels() (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(localBuildDir, localLabelsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no local build found, please run 'rhino build --local' first")
+	} else if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", filepath.Join(localBuildDir, localLabelsFile), err)
+	}
+	return labels, nil
+}
@@ -0,0 +1,284 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The Makefile parser below only understands the common part of GNU make: variables, explicit rules
+// and their recipes. It is used to tell early whether the Makefile builds the executable rhino expects.
+
+var (
+	makeAssignment = regexp.MustCompile(`^(?:override\s+|export\s+)?([A-Za-z0-9_.-]+)\s*(:::=|::=|:=|\?=|\+=|!=|=)\s*(.*)$`)
+	makeVariable   = regexp.MustCompile(`\$[({]([A-Za-z0-9_.-]+)[)}]`)
+	makeVarRef     = regexp.MustCompile(`^\$[({]([A-Za-z0-9_.-]+)[)}]$`)
+)
+
+type makeVar struct {
+	value string
+	line  int
+	// set on the make command line
+	override bool
+}
+
+type makeRule struct {
+	target  string
+	line    int
+	recipes []makeRecipe
+}
+
+type makeRecipe struct {
+	text string
+	line int
+}
+
+type makefileInfo struct {
+	vars  map[string]makeVar
+	rules []makeRule
+	phony map[string]bool
+	// the Makefile includes other files or uses variables it cannot expand, so it is not fully known
+	incomplete bool
+}
+
+// parseMakefile reads the variables and the rules of a Makefile, makeArgs are the arguments of make
+func parseMakefile(r io.Reader, makeArgs []string) (*makefileInfo, error) {
+	info := &makefileInfo{vars: map[string]makeVar{}, phony: map[string]bool{}}
+	for _, arg := range makeArgs {
+		if m := makeAssignment.FindStringSubmatch(arg); m != nil && !strings.HasPrefix(arg, "-") {
+			info.vars[m[1]] = makeVar{value: m[3], override: true}
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	var rules []*makeRule
+	inDefine := false
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		start := lineNumber
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			lineNumber++
+			line = strings.TrimSuffix(line, "\\") + " " + strings.TrimSpace(scanner.Text())
+		}
+
+		// The body of a multi-line variable is neither assignments nor recipes
+		if inDefine {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "endef" {
+				inDefine = false
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			for _, rule := range rules {
+				rule.recipes = append(rule.recipes, makeRecipe{text: strings.TrimSpace(line), line: start})
+			}
+			continue
+		}
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		keyword := fields[0]
+		if len(fields) > 1 && (keyword == "override" || keyword == "export") && fields[1] == "define" {
+			keyword = "define"
+		}
+		switch keyword {
+		case "include", "-include", "sinclude":
+			info.incomplete = true
+			continue
+		case "define":
+			inDefine = true
+			info.incomplete = true
+			continue
+		case "ifeq", "ifneq", "ifdef", "ifndef", "else", "endif":
+			// Both branches of the conditionals are read, the last assignment may not be the one make uses
+			info.incomplete = true
+			continue
+		case "vpath":
+			continue
+		}
+
+		if m := makeAssignment.FindStringSubmatch(line); m != nil {
+			name, op, value := m[1], m[2], strings.TrimSpace(m[3])
+			old, defined := info.vars[name]
+			switch {
+			case old.override:
+			case op == "?=" && defined:
+			case op == "+=" && defined:
+				info.vars[name] = makeVar{value: strings.TrimSpace(old.value + " " + value), line: old.line}
+			case op == "!=":
+				info.incomplete = true
+			default:
+				info.vars[name] = makeVar{value: value, line: start}
+			}
+			rules = nil
+			continue
+		}
+
+		if i := strings.Index(line, ":"); i > 0 {
+			rules = nil
+			targets := strings.Fields(line[:i])
+			if len(targets) == 1 && targets[0] == ".PHONY" {
+				for _, target := range strings.Fields(strings.TrimLeft(line[i:], ":")) {
+					info.phony[target] = true
+				}
+				continue
+			}
+			for _, target := range targets {
+				info.rules = append(info.rules, makeRule{target: target, line: start})
+			}
+			for j := len(info.rules) - len(targets); j < len(info.rules); j++ {
+				rules = append(rules, &info.rules[j])
+			}
+		}
+	}
+	return info, scanner.Err()
+}
+
+// expand replaces the variables in s, and tells whether they are all known
+func (m *makefileInfo) expand(s string, autoTarget string) (string, bool) {
+	s = strings.ReplaceAll(s, "$$", "\x00")
+	s = strings.ReplaceAll(s, "$@", autoTarget)
+	known := true
+	for depth := 0; depth < 10 && strings.Contains(s, "$"); depth++ {
+		s = makeVariable.ReplaceAllStringFunc(s, func(ref string) string {
+			v, ok := m.vars[makeVariable.FindStringSubmatch(ref)[1]]
+			if !ok {
+				known = false
+			}
+			return v.value
+		})
+		if !makeVariable.MatchString(s) {
+			break
+		}
+	}
+	if strings.Contains(s, "$") {
+		known = false
+	}
+	return strings.ReplaceAll(s, "\x00", "$"), known
+}
+
+// makeOutput is an executable linked by a recipe of the Makefile
+type makeOutput struct {
+	name string
+	// the output as written in the Makefile, e.g. $(TARGET)
+	raw  string
+	line int
+}
+
+// linkedExecutables returns the executables linked by the recipes, i.e. the '-o' outputs of the commands not compiling objects
+func (m *makefileInfo) linkedExecutables() []makeOutput {
+	outputs := []makeOutput{}
+	for _, rule := range m.rules {
+		if m.phony[rule.target] || strings.Contains(rule.target, "%") || strings.HasPrefix(rule.target, ".") {
+			continue
+		}
+		for _, recipe := range rule.recipes {
+			fields := strings.Fields(recipe.text)
+			raw := ""
+			for i, field := range fields {
+				if field == "-c" {
+					raw = ""
+					break
+				}
+				if field == "-o" && i+1 < len(fields) {
+					raw = fields[i+1]
+				} else if strings.HasPrefix(field, "-o") && len(field) > 2 && raw == "" {
+					raw = field[2:]
+				}
+			}
+			if raw == "" {
+				continue
+			}
+			if raw == "$@" {
+				raw = rule.target
+			}
+			name, known := m.expand(raw, rule.target)
+			if !known {
+				m.incomplete = true
+				continue
+			}
+			outputs = append(outputs, makeOutput{name: name, raw: raw, line: recipe.line})
+		}
+	}
+	return outputs
+}
+
+// checkMakefileTarget checks that the Makefile builds the executable funcName. It returns an error if the
+// Makefile clearly builds other executables, and a warning if it is not sure.
+func checkMakefileTarget(r io.Reader, makefilePath string, makeArgs []string, funcName string) (string, error) {
+	info, err := parseMakefile(r, makeArgs)
+	if err != nil {
+		return "", err
+	}
+	for _, rule := range info.rules {
+		if target, known := info.expand(rule.target, rule.target); known && path.Base(target) == funcName {
+			return "", nil
+		}
+	}
+	outputs := info.linkedExecutables()
+	names := []string{}
+	for _, output := range outputs {
+		if path.Base(output.name) == funcName {
+			return "", nil
+		}
+		names = append(names, output.name)
+	}
+	if len(outputs) == 0 {
+		return fmt.Sprintf("cannot find how %s builds %s, please make sure the executable is named %s", makefilePath, funcName, funcName), nil
+	}
+
+	output := outputs[0]
+	suggestion := fmt.Sprintf("change %s to %s at line %d of %s", output.raw, funcName, output.line, makefilePath)
+	if ref := makeVarRef.FindStringSubmatch(output.raw); ref != nil {
+		if v, ok := info.vars[ref[1]]; ok && v.override {
+			suggestion = fmt.Sprintf("set %s=%s in the make arguments", ref[1], funcName)
+		} else if ok {
+			suggestion = fmt.Sprintf("set %s = %s at line %d of %s", ref[1], funcName, v.line, makefilePath)
+		}
+	}
+	message := fmt.Sprintf("%s builds %s instead of %s. Please %s", makefilePath, strings.Join(names, ", "), funcName, suggestion)
+	if info.incomplete {
+		return message, nil
+	}
+	return "", fmt.Errorf("%s", message)
+}
+
+// preflightMakefile fails before building if the Makefile does not build the executable, and prints its warnings to out
+func preflightMakefile(out io.Writer, makefilePath string, makeArgs []string, executable string) error {
+	f, err := os.Open(makefilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	warning, err := checkMakefileTarget(f, makefilePath, makeArgs, executable)
+	if warning != "" {
+		fmt.Fprintln(out, "Warning:", warning)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMakefileTarget(t *testing.T) {
+	// the Makefile of the template
+	template, err := readTemplateFile("func/src/Makefile")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	warning, err := checkMakefileTarget(strings.NewReader(string(template)), "Makefile", nil, "mpi-func")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	assert.Equal(t, "", warning)
+
+	renamed := strings.Replace(string(template), "TARGET = mpi-func", "TARGET = \\\n\tmatmul", 1)
+	_, err = checkMakefileTarget(strings.NewReader(renamed), "Makefile", nil, "mpi-func")
+	assert.Equal(t, fmt.Errorf("Makefile builds matmul instead of mpi-func. Please set TARGET = mpi-func at line 16 of Makefile"), err)
+
+	// the variables given to make override the Makefile
+	_, err = checkMakefileTarget(strings.NewReader(renamed), "Makefile", []string{"-j4", "TARGET=mpi-func"}, "mpi-func")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	_, err = checkMakefileTarget(strings.NewReader(string(template)), "Makefile", []string{"TARGET=bench"}, "mpi-func")
+	assert.Equal(t, fmt.Errorf("Makefile builds bench instead of mpi-func. Please set TARGET=mpi-func in the make arguments"), err)
+
+	literal := "all:\n\tmpicxx -O2 -c main.cpp -o main.o\n\tmpicxx main.o -o bin/hello\n"
+	_, err = checkMakefileTarget(strings.NewReader(literal), "Makefile", nil, "mpi-func")
+	assert.Equal(t, fmt.Errorf("Makefile builds bin/hello instead of mpi-func. Please change bin/hello to mpi-func at line 3 of Makefile"), err)
+
+	// included files may define the target, so only warn
+	warning, err = checkMakefileTarget(strings.NewReader("include config.mk\n"+literal), "Makefile", nil, "mpi-func")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	assert.Equal(t, true, strings.HasPrefix(warning, "Makefile builds bin/hello instead of mpi-func"), "test failed: unexpected warning %s", warning)
+
+	// both branches of a conditional are read, so only warn
+	conditional := "ifeq ($(MPI),1)\nTARGET = mpi-func\nelse\nTARGET = serial\nendif\n$(TARGET): main.o\n\tmpicxx main.o -o $(TARGET)\n"
+	warning, err = checkMakefileTarget(strings.NewReader(conditional), "Makefile", nil, "mpi-func")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	assert.Equal(t, true, strings.HasPrefix(warning, "Makefile builds serial instead of mpi-func"), "test failed: unexpected warning %s", warning)
+
+	// the body of a define is not an assignment
+	defined := string(template) + "\ndefine RENAME\nTARGET = bench\n\tmpicxx main.o -o bench\nendef\n"
+	warning, err = checkMakefileTarget(strings.NewReader(defined), "Makefile", nil, "mpi-func")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	assert.Equal(t, "", warning)
+
+	warning, err = checkMakefileTarget(strings.NewReader("all:\n\tcmake --build build\n"), "Makefile", nil, "mpi-func")
+	assert.Equal(t, nil, err, "test check makefile failed: %s", errorMessage(err))
+	assert.Equal(t, "cannot find how Makefile builds mpi-func, please make sure the executable is named mpi-func", warning)
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"sigs.k8s.io/yaml"
+)
+
+const manifestFileName = "rhino.yaml"
+
+// ProjectManifest holds the settings of a function project, read from rhino.yaml in the project root
+type ProjectManifest struct {
+	Build        BuildSpec        `json:"build,omitempty"`
+	Libraries    LibrariesSpec    `json:"libraries,omitempty"`
+	Dependencies DependenciesSpec `json:"dependencies,omitempty"`
+	Matrix       []MatrixVariant  `json:"matrix,omitempty"`
+}
+
+// BuildSpec holds the defaults of 'rhino build', the options given on the command line take precedence
+type BuildSpec struct {
+	// make, cmake, meson, autotools or script, detected from the files in src if empty
+	BuildSystem string `json:"buildSystem,omitempty"`
+	// The relative path of the Makefile, or of the build file of the build system
+	File string `json:"file,omitempty"`
+	// The name of the executable built, mpi-func by default
+	Executable   string `json:"executable,omitempty"`
+	MPI          string `json:"mpi,omitempty"`
+	BuilderImage string `json:"builderImage,omitempty"`
+	RuntimeImage string `json:"runtimeImage,omitempty"`
+}
+
+var validExecutableName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func (b *BuildSpec) validate() error {
+	if b.BuildSystem != "" {
+		if err := validateBuildSystem(b.BuildSystem); err != nil {
+			return err
+		}
+	}
+	if b.MPI != "" {
+		if err := validateMPIFlavor(b.MPI); err != nil {
+			return err
+		}
+	}
+	if b.Executable != "" && !validExecutableName.MatchString(b.Executable) {
+		return fmt.Errorf("build.executable must be a file name, e.g. %s", funcExecName)
+	}
+	return nil
+}
+
+// LibrariesSpec controls which shared libraries are bundled into the function image
+type LibrariesSpec struct {
+	// Sonames or glob patterns bundled even if the runtime image provides them,
+	// and absolute paths of extra libraries, e.g. plugins loaded with dlopen
+	Include []string `json:"include,omitempty"`
+	// Sonames or glob patterns never bundled
+	Exclude []string `json:"exclude,omitempty"`
+	// Directories searched before the default library directories
+	SearchPaths []string `json:"searchPaths,omitempty"`
+}
+
+// DependenciesSpec lists the system packages installed into the stages of the function image
+type DependenciesSpec struct {
+	// The package manager of the base images, apk or apt. If empty, the one found in the image is used.
+	PackageManager string `json:"packageManager,omitempty"`
+	// Packages installed in the builder stage, e.g. fftw-dev
+	Build []string `json:"build,omitempty"`
+	// Packages installed in the runtime stage, e.g. fftw
+	Runtime []string `json:"runtime,omitempty"`
+	// Spack specs installed in the builder stage, e.g. "petsc@3.19 +mpi".
+	// The builder image must provide Spack, the shared libraries are bundled into the function image.
+	Spack []string `json:"spack,omitempty"`
+}
+
+// MatrixVariant is a variant of the function built by 'rhino build --matrix'
+type MatrixVariant struct {
+	// The suffix of the image tag, e.g. o3 builds foo/bar:v1-o3
+	Name string `json:"name"`
+	// Arguments appended to the build command, e.g. CXXFLAGS=-O3 for make or -DCMAKE_CXX_FLAGS=-O3 for CMake
+	Args []string `json:"args,omitempty"`
+}
+
+func validateMatrix(matrix []MatrixVariant) error {
+	names := map[string]bool{}
+	for _, variant := range matrix {
+		if !validExecutableName.MatchString(variant.Name) || len(variant.Name) > 32 {
+			return fmt.Errorf("invalid matrix variant name %q, it can only contain letters, digits, '.', '_' and '-'", variant.Name)
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("duplicate matrix variant %s", variant.Name)
+		}
+		names[variant.Name] = true
+	}
+	return nil
+}
+
+var (
+	validPackageName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_:=~<>-]*$`)
+	validSpackSpec   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9@%+=:,./_~^ -]*$`)
+)
+
+func (d *DependenciesSpec) validate() error {
+	if d.PackageManager != "" && d.PackageManager != "apk" && d.PackageManager != "apt" {
+		return fmt.Errorf("dependencies.packageManager must be either apk or apt")
+	}
+	for _, name := range append(append([]string{}, d.Build...), d.Runtime...) {
+		if !validPackageName.MatchString(name) {
+			return fmt.Errorf("invalid package name %q in dependencies", name)
+		}
+	}
+	for _, spec := range d.Spack {
+		if !validSpackSpec.MatchString(spec) {
+			return fmt.Errorf("invalid Spack spec %q in dependencies", spec)
+		}
+	}
+	return nil
+}
+
+// loadManifest reads the manifest in dir. A project without a manifest gets an empty one.
+func loadManifest(dir string) (*ProjectManifest, error) {
+	manifest := &ProjectManifest{}
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", manifestFileName, err)
+	}
+	if err := manifest.Build.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", manifestFileName, err)
+	}
+	if err := manifest.Dependencies.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", manifestFileName, err)
+	}
+	if err := validateMatrix(manifest.Matrix); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", manifestFileName, err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	// a project without manifest gets the defaults
+	manifest, err := loadManifest(dir)
+	assert.Equal(t, nil, err, "test load manifest failed: %s", errorMessage(err))
+	assert.Equal(t, &ProjectManifest{}, manifest)
+
+	content := "libraries:\n  include: [libfoo.so.1]\n  searchPaths: [/opt/foo/lib]\n"
+	os.WriteFile(filepath.Join(dir, manifestFileName), []byte(content), 0644)
+	manifest, err = loadManifest(dir)
+	assert.Equal(t, nil, err, "test load manifest failed: %s", errorMessage(err))
+	assert.Equal(t, []string{"libfoo.so.1"}, manifest.Libraries.Include)
+	assert.Equal(t, []string{"/opt/foo/lib"}, manifest.Libraries.SearchPaths)
+
+	// unknown fields are reported
+	os.WriteFile(filepath.Join(dir, manifestFileName), []byte("libraries:\n  includes: [libfoo.so.1]\n"), 0644)
+	_, err = loadManifest(dir)
+	assert.Equal(t, true, err != nil, "test failed: unknown field not reported")
+
+	// package names are checked
+	os.WriteFile(filepath.Join(dir, manifestFileName), []byte("dependencies:\n  build: [\"fftw; rm -rf /\"]\n"), 0644)
+	_, err = loadManifest(dir)
+	assert.Equal(t, "invalid rhino.yaml: invalid package name \"fftw; rm -rf /\" in dependencies", errorMessage(err))
+
+	// the executable must stay in the project
+	manifest.Build.Executable = "../matmul"
+	assert.Equal(t, "build.executable must be a file name, e.g. mpi-func", errorMessage(manifest.Build.validate()))
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// matrixResult is the outcome of the build of a variant
+type matrixResult struct {
+	variant  string
+	image    string
+	log      string
+	duration time.Duration
+	err      error
+}
+
+// buildMatrix builds the variants of the manifest with at most b.jobs builds at the same time
+func (b *BuildOptions) buildMatrix(args []string) error {
+	manifest, err := loadManifest(".")
+	if err != nil {
+		return err
+	}
+	if len(manifest.Matrix) == 0 {
+		return fmt.Errorf("no matrix found in %s, please add the variants to build", manifestFileName)
+	}
+	jobs := b.jobs
+	separate := matrixConcurrencySupported()
+	if !separate {
+		fmt.Println("Warning: the Dockerfile collects every variant into", buildDir, "so they are built one by one. Run 'rhino eject --force' to update it")
+		jobs = 1
+	}
+	if jobs > len(manifest.Matrix) {
+		jobs = len(manifest.Matrix)
+	}
+	baseCommand := args
+	if len(baseCommand) == 0 {
+		baseCommand = []string{buildTool(b.buildSystem, b.buildFilePath())}
+	}
+
+	results := make([]matrixResult, len(manifest.Matrix))
+	variants := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range variants {
+				results[j] = b.buildVariant(manifest.Matrix[j], baseCommand, separate)
+			}
+		}()
+	}
+	for i := range manifest.Matrix {
+		variants <- i
+	}
+	close(variants)
+	wg.Wait()
+
+	fmt.Println()
+	if err := printMatrixSummary(os.Stdout, results); err != nil {
+		return err
+	}
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d variants failed", failed, len(results))
+	}
+	return nil
+}
+
+// buildVariant builds a variant into its own image, and into its own output directory if separate is true.
+// The output of the build goes to a log file.
+func (b *BuildOptions) buildVariant(variant MatrixVariant, baseCommand []string, separate bool) matrixResult {
+	v := *b
+	v.matrix = false
+	v.variant = variant.Name
+	v.image = variantImage(b.image, variant.Name)
+	variantDir := filepath.Join(buildDir, variant.Name)
+	if separate {
+		v.outputDir = variantDir
+	}
+	result := matrixResult{variant: variant.Name, image: v.image, log: filepath.Join(variantDir, "build.log")}
+
+	start := time.Now()
+	if err := os.MkdirAll(variantDir, 0755); err != nil {
+		result.err = err
+		return result
+	}
+	logFile, err := os.Create(result.log)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer logFile.Close()
+	v.log = logFile
+
+	fmt.Printf("Building %s with %s\n", v.image, strings.Join(variant.Args, " "))
+	result.err = v.build(append(append([]string{}, baseCommand...), variant.Args...))
+	if result.err != nil {
+		fmt.Fprintln(logFile, "Error:", result.err)
+		fmt.Printf("Failed to build %s: %v\n", v.image, result.err)
+	} else {
+		fmt.Println("Built", v.image)
+	}
+	result.duration = time.Since(start)
+	return result
+}
+
+// variantImage adds the name of the variant to the tag of image, e.g. foo/bar:v1 -> foo/bar:v1-o3
+func variantImage(image string, variant string) string {
+	tag := imageTag(image)
+	return strings.TrimSuffix(image, ":"+tag) + ":" + tag + "-" + variant
+}
+
+// matrixConcurrencySupported tells whether the Dockerfile takes the output directory of the variants as a build arg
+func matrixConcurrencySupported() bool {
+	dockerfile, err := os.ReadFile(projectDockerfile)
+	if err != nil {
+		// The Dockerfile rendered from the template supports it
+		return true
+	}
+	return strings.Contains(string(dockerfile), "build_dir") || strings.Contains(string(dockerfile), "ldd.sh")
+}
+
+func printMatrixSummary(w io.Writer, results []matrixResult) error {
+	tw := tabwriter.NewWriter(w, 2, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tIMAGE\tSTATUS\tDURATION\tLOG")
+	for _, result := range results {
+		status := "ok"
+		if result.err != nil {
+			status = "failed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\n", result.variant, result.image, status, result.duration.Round(time.Second), result.log)
+	}
+	return tw.Flush()
+}
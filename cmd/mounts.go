@@ -0,0 +1,228 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The operator mounts the dataPath of a job at this path of every MPI process
+const jobDataDir = "/data"
+
+// The names of docker volumes, as opposed to host paths
+var validVolumeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseVolume checks a volume in the format <host-path>:<container-path>[:ro|rw] and returns it as a bind of docker.
+// Host paths are made absolute and must exist, names without a slash are docker volumes.
+func parseVolume(volume string) (string, error) {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid volume %s, the format is <host-path>:<container-path>[:ro]", volume)
+	}
+	source, target := parts[0], parts[1]
+	if !path.IsAbs(target) {
+		return "", fmt.Errorf("invalid volume %s, the container path %s must be absolute", volume, target)
+	}
+	if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+		return "", fmt.Errorf("invalid volume %s, the mode must be ro or rw", volume)
+	}
+
+	if !validVolumeName.MatchString(source) || strings.HasPrefix(source, ".") {
+		if strings.HasPrefix(source, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			source = filepath.Join(home, source[2:])
+		}
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return "", fmt.Errorf("the host path %s of the volume %s does not exist", source, volume)
+		}
+		source = abs
+	}
+	parts[0] = source
+	return strings.Join(parts, ":"), nil
+}
+
+// parseTmpfs checks a tmpfs mount in the format <container-path>[:<options>], e.g. /scratch:size=1g
+func parseTmpfs(tmpfs string) (string, string, error) {
+	target, options, _ := strings.Cut(tmpfs, ":")
+	if !path.IsAbs(target) {
+		return "", "", fmt.Errorf("invalid tmpfs %s, the container path must be absolute", tmpfs)
+	}
+	return target, options, nil
+}
+
+// parseEnv checks an environment variable in the format KEY=VALUE, or KEY to take the value of this environment.
+// Variables without value which are not set here are left out, as docker does.
+func parseEnv(env string) (string, bool, error) {
+	name, _, hasValue := strings.Cut(env, "=")
+	if !validEnvName.MatchString(name) {
+		return "", false, fmt.Errorf("invalid environment variable %s, the format is KEY=VALUE", env)
+	}
+	if hasValue {
+		return env, true, nil
+	}
+	value, ok := os.LookupEnv(name)
+	return name + "=" + value, ok, nil
+}
+
+// readEnvFile reads the environment variables of a file, one KEY=VALUE per line, # starting comments
+func readEnvFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the env file: %v", err)
+	}
+	defer f.Close()
+
+	envs := []string{}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env, ok, err := parseEnv(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of %s: %v", lineNumber, name, err)
+		}
+		if ok {
+			envs = append(envs, env)
+		}
+	}
+	return envs, scanner.Err()
+}
+
+// binds returns the volumes of the container in the format of docker
+func (r *DockerRunOptions) binds() ([]string, error) {
+	binds := []string{}
+	if r.dataDir != "" {
+		// Like the NFS directory of 'rhino run --server --dir', shared by all the containers
+		bind, err := parseVolume(r.dataDir + ":" + jobDataDir)
+		if err != nil {
+			return nil, err
+		}
+		binds = append(binds, bind)
+	}
+	for _, volume := range r.volumes {
+		bind, err := parseVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		binds = append(binds, bind)
+	}
+	return binds, nil
+}
+
+// tmpfsMounts returns the tmpfs mounts of the container, with their options
+func (r *DockerRunOptions) tmpfsMounts() (map[string]string, error) {
+	if len(r.tmpfs) == 0 {
+		return nil, nil
+	}
+	mounts := map[string]string{}
+	for _, tmpfs := range r.tmpfs {
+		target, options, err := parseTmpfs(tmpfs)
+		if err != nil {
+			return nil, err
+		}
+		mounts[target] = options
+	}
+	return mounts, nil
+}
+
+// userEnv returns the environment variables of --env-file, of -e then of --threads-per-rank, the last value of a variable wins
+func (r *DockerRunOptions) userEnv() ([]string, error) {
+	envs := []string{}
+	for _, envFile := range r.envFiles {
+		fileEnvs, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, fileEnvs...)
+	}
+	for _, env := range r.env {
+		env, ok, err := parseEnv(env)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			envs = append(envs, env)
+		}
+	}
+	if r.threadsPerRank > 0 {
+		envs = append(envs, "OMP_NUM_THREADS="+strconv.Itoa(r.threadsPerRank))
+	}
+	return envs, nil
+}
+
+// validateMounts checks the volumes, the tmpfs mounts, the environment and the working directory up front
+func (r *DockerRunOptions) validateMounts() error {
+	if r.dataDir != "" {
+		info, err := os.Stat(r.dataDir)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("the data directory (--data-dir) %s does not exist", r.dataDir)
+		}
+	}
+	if _, err := r.binds(); err != nil {
+		return err
+	}
+	if r.dataDir != "" {
+		for _, volume := range r.volumes {
+			if parts := strings.Split(volume, ":"); path.Clean(parts[1]) == jobDataDir {
+				return fmt.Errorf("the volume %s is mounted at %s, which is used by --data-dir", volume, jobDataDir)
+			}
+		}
+	}
+	if _, err := r.tmpfsMounts(); err != nil {
+		return err
+	}
+	if _, err := r.userEnv(); err != nil {
+		return err
+	}
+	if r.workdir != "" && !path.IsAbs(r.workdir) {
+		return fmt.Errorf("the working directory (-w) %s must be an absolute path in the container", r.workdir)
+	}
+	return nil
+}
+
+// exportEnvArgs returns the mpirun arguments passing the environment variables envs to the processes on other nodes
+func exportEnvArgs(mpi string, envs []string) []string {
+	args := []string{}
+	if mpi == MPIMPICH {
+		// Hydra passes the whole environment by default
+		return args
+	}
+	for _, env := range envs {
+		name, _, _ := strings.Cut(env, "=")
+		args = append(args, "-x", name)
+	}
+	return args
+}
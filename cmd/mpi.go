@@ -0,0 +1,167 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The MPI implementations supported by the build templates and the local launchers
+const (
+	MPIOpenMPI = "openmpi"
+	MPIMPICH   = "mpich"
+)
+
+// The default base images are Alpine (musl) images shipping OpenMPI
+const (
+	defaultBuilderImage = "openrhino/mpibuilder_base:v0.1.0"
+	defaultRuntimeImage = "openrhino/mpirun_base:v0.1.0"
+)
+
+func validateMPIFlavor(mpi string) error {
+	if mpi != MPIOpenMPI && mpi != MPIMPICH {
+		return fmt.Errorf("the MPI implementation (--mpi) must be either %s or %s", MPIOpenMPI, MPIMPICH)
+	}
+	return nil
+}
+
+// The memory allocation modes of RHINO jobs: the memory of the whole job, or of each MPI process
+const (
+	FixedTotalMemory   = "FixedTotalMemory"
+	FixedPerCoreMemory = "FixedPerCoreMemory"
+)
+
+func validateMemoryOptions(mode string, size int) error {
+	if mode != FixedTotalMemory && mode != FixedPerCoreMemory {
+		return fmt.Errorf("the memory allocation mode (--mem-mode) must be either FixedTotalMemory or FixedPerCoreMemory")
+	}
+	if size < 1 {
+		return fmt.Errorf("the memory allocation size (--mem-size) must be greater than or equal to 1")
+	}
+	return nil
+}
+
+// memoryLimit returns the memory in bytes allocated to processes of the np MPI processes of a job
+func memoryLimit(mode string, size int, np int, processes int) int64 {
+	if mode == FixedTotalMemory {
+		return (int64(size) << 30) * int64(processes) / int64(np)
+	}
+	return (int64(size) << 30) * int64(processes)
+}
+
+// validateRunOptions checks the options shared by the local launchers
+func validateRunOptions(parallel int, mpi string) error {
+	if parallel < 1 {
+		return fmt.Errorf("the number of MPI processes (--np) must be greater than 0")
+	}
+	return validateMPIFlavor(mpi)
+}
+
+// mpirunCommand returns the command launching np processes of execPath with the given MPI implementation
+func mpirunCommand(mpi string, np int, execPath string) []string {
+	if mpi == MPIMPICH {
+		// Hydra uses ssh to start processes by default, all ranks are local here
+		return []string{"mpiexec", "-launcher", "fork", "-n", strconv.Itoa(np), execPath}
+	}
+	return []string{"mpirun", "-np", strconv.Itoa(np), execPath}
+}
+
+// mpirunHostfileCommand returns the command launching np processes of execPath on the hosts of hostfile
+func mpirunHostfileCommand(mpi string, np int, hostfile string, execPath string) []string {
+	if mpi == MPIMPICH {
+		return []string{"mpiexec", "-launcher", "ssh", "-f", hostfile, "-n", strconv.Itoa(np), execPath}
+	}
+	return []string{"mpirun", "--hostfile", hostfile, "-np", strconv.Itoa(np), execPath}
+}
+
+// hostfileEntry returns the line of a hostfile giving slots processes to host
+func hostfileEntry(mpi string, host string, slots int) string {
+	if mpi == MPIMPICH {
+		return host + ":" + strconv.Itoa(slots)
+	}
+	return host + " slots=" + strconv.Itoa(slots)
+}
+
+// The hardware resources both OpenMPI and the Hydra launcher of MPICH map and bind processes to
+var mpiResources = []string{"hwthread", "core", "l1cache", "l2cache", "l3cache", "socket", "numa", "board"}
+
+func isMPIResource(name string) bool {
+	for _, resource := range mpiResources {
+		if name == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// mpirunPlacementArgs translates the mapping, binding and oversubscription options in the syntax of
+// OpenMPI, which are given to mpirun as is, into the options of the launcher of the MPI implementation
+func mpirunPlacementArgs(mpi string, mapBy string, bindTo string, oversubscribe bool) ([]string, error) {
+	args := []string{}
+	if mpi != MPIMPICH {
+		if mapBy != "" {
+			args = append(args, "--map-by", mapBy)
+		}
+		if bindTo != "" {
+			args = append(args, "--bind-to", bindTo)
+		}
+		if oversubscribe {
+			args = append(args, "--oversubscribe")
+		}
+		return args, nil
+	}
+
+	// Hydra fills the slots of the hosts in order by default, and starts more processes than slots
+	// by wrapping around the hosts, so that slot mapping and oversubscription need no option
+	switch {
+	case mapBy == "" || mapBy == "slot":
+	case mapBy == "node":
+		args = append(args, "-rr")
+	case isMPIResource(mapBy):
+		args = append(args, "-map-by", mapBy)
+	default:
+		return nil, fmt.Errorf("--map-by %s is not supported with %s, choose from [slot, node, %s], or pass the options of mpiexec with --mpi-arg",
+			mapBy, MPIMPICH, strings.Join(mpiResources, ", "))
+	}
+	if bindTo != "" {
+		if bindTo != "none" && !isMPIResource(bindTo) {
+			return nil, fmt.Errorf("--bind-to %s is not supported with %s, choose from [none, %s], or pass the options of mpiexec with --mpi-arg",
+				bindTo, MPIMPICH, strings.Join(mpiResources, ", "))
+		}
+		args = append(args, "-bind-to", bindTo)
+	}
+	return args, nil
+}
+
+// mpiEnv returns the environment variables needed by the MPI implementation in a local container
+func mpiEnv(mpi string) []string {
+	if mpi == MPIMPICH {
+		return []string{}
+	}
+	return []string{
+		"OMPI_MCA_btl_base_warn_component_unused=0", // Suppress OpenMPI warning
+	}
+}
+
+// mpiLibraryPatterns returns the shared libraries of the MPI implementation, which the runtime image provides
+func mpiLibraryPatterns(mpi string) []string {
+	if mpi == MPIMPICH {
+		return []string{"libmpi.so*", "libmpicxx.so*", "libmpifort.so*", "libmpich*.so*", "libmpl.so*", "libopa.so*"}
+	}
+	return []string{"libmpi.so*", "libmpi_cxx.so*", "libmpi_mpifh.so*", "libopen-rte.so*", "libopen-pal.so*"}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// The pull policies of the images run locally
+const (
+	PullAlways  = "always"
+	PullMissing = "missing"
+	PullNever   = "never"
+)
+
+// The registry of the images without a registry host, and its key in the docker config file
+const (
+	dockerHubRegistry  = "docker.io"
+	dockerHubServerURL = "https://index.docker.io/v1/"
+)
+
+func validatePullPolicy(policy string) error {
+	if policy != PullAlways && policy != PullMissing && policy != PullNever {
+		return fmt.Errorf("the pull policy (--pull) must be one of always, missing or never")
+	}
+	return nil
+}
+
+// imageRegistry returns the registry host of image, e.g. docker.io for foo/bar:v1
+func imageRegistry(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return dockerHubRegistry
+}
+
+// dockerConfigFile is the part of the docker config file holding the credentials of the registries
+type dockerConfigFile struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// loadDockerConfig reads the config file of the docker CLI, in $DOCKER_CONFIG or ~/.docker
+func loadDockerConfig() (*dockerConfigFile, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &dockerConfigFile{}, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return &dockerConfigFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	config := &dockerConfigFile{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid docker config file: %v", err)
+	}
+	return config, nil
+}
+
+// serverURL returns the key of the registry in the docker config file and in the credential helpers
+func serverURL(registry string) string {
+	if registry == dockerHubRegistry {
+		return dockerHubServerURL
+	}
+	return registry
+}
+
+// authConfig returns the credentials of registry, from its credential helper or from the auths of the config file.
+// It returns nil if there are none.
+func (c *dockerConfigFile) authConfig(registry string, credentialHelper func(helper string, serverURL string) (*types.AuthConfig, error)) (*types.AuthConfig, error) {
+	server := serverURL(registry)
+	helper := c.CredsStore
+	if h, ok := c.CredHelpers[registry]; ok {
+		helper = h
+	}
+	if helper != "" {
+		auth, err := credentialHelper(helper, server)
+		if err != nil || auth != nil {
+			return auth, err
+		}
+	}
+
+	for key, auth := range c.Auths {
+		host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		host, _, _ = strings.Cut(host, "/")
+		if key != server && host != registry && !(registry == dockerHubRegistry && host == "index.docker.io") {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid credentials of %s in the docker config file", key)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+			auth.Auth = ""
+		}
+		auth.ServerAddress = server
+		return &auth, nil
+	}
+	return nil, nil
+}
+
+// runCredentialHelper gets the credentials of serverURL from the docker credential helper, nil if it has none
+func runCredentialHelper(helper string, serverURL string) (*types.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get the credentials of %s from docker-credential-%s: %v %s", serverURL, helper, err, output)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("invalid output of docker-credential-%s: %v", helper, err)
+	}
+	auth := &types.AuthConfig{ServerAddress: serverURL}
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, nil
+}
+
+// registryAuth returns the credentials of the registry of image encoded for the docker API, empty if there are none
+func registryAuth(image string) (string, error) {
+	config, err := loadDockerConfig()
+	if err != nil {
+		return "", err
+	}
+	auth, err := config.authConfig(imageRegistry(image), runCredentialHelper)
+	if err != nil || auth == nil {
+		return "", err
+	}
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	assert.Equal(t, "docker.io", imageRegistry("openrhino/mpirun_base:v0.1.0"))
+	assert.Equal(t, "docker.io", imageRegistry("hello:v1.0"))
+	assert.Equal(t, "registry.example.com:5000", imageRegistry("registry.example.com:5000/foo/bar:v1"))
+	assert.Equal(t, "localhost", imageRegistry("localhost/bar"))
+	assert.Equal(t, fmt.Errorf("the pull policy (--pull) must be one of always, missing or never"), validatePullPolicy("if-not-present"))
+
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "Zm9vOnBhc3M6d29yZA=="},
+    "registry.example.com": {}
+  },
+  "credHelpers": {"registry.example.com": "example"}
+}`), 0600)
+	config, err := loadDockerConfig()
+	assert.Equal(t, nil, err, errorMessage(err))
+
+	helpers := []string{}
+	helper := func(helper string, serverURL string) (*types.AuthConfig, error) {
+		helpers = append(helpers, helper+" "+serverURL)
+		return &types.AuthConfig{IdentityToken: "token", ServerAddress: serverURL}, nil
+	}
+	auth, err := config.authConfig("docker.io", helper)
+	assert.Equal(t, nil, err, errorMessage(err))
+	assert.Equal(t, &types.AuthConfig{Username: "foo", Password: "pass:word", ServerAddress: dockerHubServerURL}, auth)
+	auth, err = config.authConfig("registry.example.com", helper)
+	assert.Equal(t, nil, err, errorMessage(err))
+	assert.Equal(t, &types.AuthConfig{IdentityToken: "token", ServerAddress: "registry.example.com"}, auth)
+	assert.Equal(t, []string{"example registry.example.com"}, helpers)
+	auth, err = config.authConfig("ghcr.io", helper)
+	assert.Equal(t, nil, err, errorMessage(err))
+	assert.Equal(t, true, auth == nil, "test failed: unexpected credentials of ghcr.io")
+}
@@ -31,6 +31,13 @@ func NewRootCommand() *cobra.Command {
 	rootCmd.AddCommand(NewRunCommand())
 	rootCmd.AddCommand(NewListCommand())
 	rootCmd.AddCommand(NewDockerRunCommand())
+	rootCmd.AddCommand(NewDockerPsCommand())
+	rootCmd.AddCommand(NewDockerLogsCommand())
+	rootCmd.AddCommand(NewDockerStopCommand())
+	rootCmd.AddCommand(NewLocalRunCommand())
+	rootCmd.AddCommand(NewDevCommand())
+	rootCmd.AddCommand(NewImageCommand())
+	rootCmd.AddCommand(NewEjectCommand())
 	rootCmd.AddCommand(NewVersionCommand())
 	return rootCmd
 }
@@ -35,6 +35,7 @@ type RunOptions struct {
 	dataPath   string
 	dataServer string
 	funcName   string
+	executable string
 
 	//the fields in v1alpha2 API
 	memoryAllocationMode string
@@ -61,7 +62,7 @@ func NewRunCommand() *cobra.Command {
 	runCmd.MarkFlagsRequiredTogether("server", "dir")
 	runCmd.Flags().IntVar(&runOpts.parallel, "np", 1, "the number of MPI processes")
 	runCmd.Flags().IntVarP(&runOpts.timeToLive, "ttl", "t", 600, "Time To Live (seconds). The RHINO job will be deleted after this time, whether it is completed or not.")
-	runCmd.Flags().StringVar(&runOpts.memoryAllocationMode, "mem-mode", "FixedPerCoreMemory", "the memory allocation mode of the RHINO job, choose from [FixedTotalMemory, FixedPerCoreMemory]")
+	runCmd.Flags().StringVar(&runOpts.memoryAllocationMode, "mem-mode", FixedPerCoreMemory, "the memory allocation mode of the RHINO job, choose from [FixedTotalMemory, FixedPerCoreMemory]")
 	runCmd.Flags().IntVar(&runOpts.memoryAllocationSize, "mem-size", 2, "the memory allocation size of the RHINO job, in GB")
 	runCmd.Flags().StringVarP(&runOpts.namespace, "namespace", "n", "", "the namespace of the RHINO job")
 	runCmd.Flags().StringVar(&runOpts.kubeconfig, "kubeconfig", "", "the path of the kubeconfig file")
@@ -82,11 +83,19 @@ func (r *RunOptions) run(cmd *cobra.Command, args []string) error {
 	if r.timeToLive < 0 {
 		return fmt.Errorf("the time to live (--ttl) must be greater than or equal to 0")
 	}
-	if r.memoryAllocationMode != "FixedTotalMemory" && r.memoryAllocationMode != "FixedPerCoreMemory" {
-		return fmt.Errorf("the memory allocation mode (--mem-mode) must be either FixedTotalMemory or FixedPerCoreMemory")
+	if err := validateMemoryOptions(r.memoryAllocationMode, r.memoryAllocationSize); err != nil {
+		return err
 	}
-	if r.memoryAllocationSize < 1 {
-		return fmt.Errorf("the memory allocation size (--mem-size) must be greater than or equal to 1")
+
+	// Use the executable recorded by 'rhino build', if the image is available locally
+	r.executable = defaultFuncExecutable
+	if labels := localImageLabels(args[0]); labels != nil {
+		if labels[executableLabel] != "" {
+			r.executable = labels[executableLabel]
+		}
+		for _, warning := range imageWarnings(args[0], labels) {
+			fmt.Println("Warning:", warning)
+		}
 	}
 
 	var err error
@@ -137,7 +146,7 @@ spec:
   	yamlFile += r.memoryAllocationMode + `
   memoryAllocationSize: `
     yamlFile += strconv.Itoa(r.memoryAllocationSize) + `
-  appExec: "/app/mpi-func"`
+  appExec: "` + r.executable + `"`
 	if len(args) > 1 {
 		yamlFile += `
   appArgs: [`
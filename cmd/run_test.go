@@ -37,6 +37,7 @@ func TestRunSingleJob(t *testing.T) {
 	rootCmd := NewRootCommand()
 	// use `rhino build` to build template
 	os.Chdir("templates/func")
+	cleanBuildOutputs(t)
 	testFuncImageName := "test-run-func-cpp:v1"
 	rootCmd.SetArgs([]string{"build", "--image", testFuncImageName})
 	err = rootCmd.Execute()
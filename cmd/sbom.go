@@ -0,0 +1,184 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// The SBOM is also stored as a file in the image, in case the labels are lost
+const sbomImagePath = "/app/sbom.spdx.json"
+
+// The subset of SPDX 2.3 (https://spdx.github.io/spdx-spec/v2.3/) used to describe function images
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files,omitempty"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+}
+
+type spdxFile struct {
+	SPDXID    string         `json:"SPDXID"`
+	FileName  string         `json:"fileName"`
+	Checksums []spdxChecksum `json:"checksums"`
+	Comment   string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// imagePurl returns the package URL of a docker image, e.g. pkg:docker/foo/bar@v1
+func imagePurl(image string, digest string) string {
+	version := imageTag(image)
+	name := strings.TrimSuffix(image, ":"+version)
+	if strings.HasPrefix(digest, "sha256:") {
+		version = digest
+	}
+	// The version is percent-encoded, e.g. sha256%3Aabcd
+	return "pkg:docker/" + name + "@" + url.QueryEscape(version)
+}
+
+// sbomNamespace returns the unique namespace of the SBOM of an image, which only changes with its content
+func sbomNamespace(funcName string, image string, report *DependencyReport) string {
+	h := sha256.New()
+	fmt.Fprintln(h, image)
+	fmt.Fprintln(h, report.ExecutableSHA256)
+	for _, lib := range report.Bundled {
+		fmt.Fprintln(h, lib.Name, lib.SHA256)
+	}
+	return "https://openrhino.org/spdx/" + funcName + "-" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// newSBOM returns the SBOM of a function image. It is copied into the image, so it only depends on the sources
+// and on the files of the image: it is created at the time of the sources if known, not at the time of the build.
+func newSBOM(src *buildProvenance, report *DependencyReport) *spdxDocument {
+	funcName := getFuncName(src.image)
+
+	sourceInfo := fmt.Sprintf("built by rhino %s from %s with make args [%s]", RHINOCLIENTVERSION, src.makefile, src.makeArgs)
+	if src.gitCommit != "" {
+		sourceInfo += ", git commit " + src.gitCommit
+		if src.gitDirty {
+			sourceInfo += " with uncommitted changes"
+		}
+	}
+	function := spdxPackage{
+		SPDXID:                "SPDXRef-Package-function",
+		Name:                  funcName,
+		DownloadLocation:      "NOASSERTION",
+		SourceInfo:            sourceInfo,
+		ExternalRefs:          []spdxExternalRef{{"PACKAGE-MANAGER", "purl", imagePurl(src.image, "")}},
+		PrimaryPackagePurpose: "CONTAINER",
+	}
+	runtime := spdxPackage{
+		SPDXID:                "SPDXRef-Package-runtime-image",
+		Name:                  src.runtimeImage,
+		DownloadLocation:      "NOASSERTION",
+		ExternalRefs:          []spdxExternalRef{{"PACKAGE-MANAGER", "purl", imagePurl(src.runtimeImage, src.runtimeImageDigest)}},
+		PrimaryPackagePurpose: "CONTAINER",
+	}
+	if strings.HasPrefix(src.runtimeImageDigest, "sha256:") {
+		runtime.Checksums = []spdxChecksum{{"SHA256", strings.TrimPrefix(src.runtimeImageDigest, "sha256:")}}
+	}
+	builder := spdxPackage{
+		SPDXID:                "SPDXRef-Package-builder-image",
+		Name:                  src.builderImage,
+		DownloadLocation:      "NOASSERTION",
+		PrimaryPackagePurpose: "CONTAINER",
+	}
+
+	created := src.sourceDate
+	if created.IsZero() {
+		created = src.created
+	}
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              src.image,
+		DocumentNamespace: sbomNamespace(funcName, src.image, report),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: rhino-" + RHINOCLIENTVERSION},
+		},
+		Packages: []spdxPackage{function, runtime, builder},
+		Relationships: []spdxRelationship{
+			{"SPDXRef-DOCUMENT", "DESCRIBES", function.SPDXID},
+			{function.SPDXID, "DESCENDANT_OF", runtime.SPDXID},
+			{builder.SPDXID, "BUILD_TOOL_OF", function.SPDXID},
+		},
+	}
+
+	doc.Files = append(doc.Files, spdxFile{
+		SPDXID:    "SPDXRef-File-executable",
+		FileName:  "/app/" + path.Base(report.Executable),
+		Checksums: []spdxChecksum{{"SHA256", report.ExecutableSHA256}},
+		Comment:   "built at " + report.Executable + " in the builder image",
+	})
+	for i, lib := range report.Bundled {
+		doc.Files = append(doc.Files, spdxFile{
+			SPDXID:    fmt.Sprintf("SPDXRef-File-library-%d", i),
+			FileName:  path.Join(bundledLibraryDir, lib.Name),
+			Checksums: []spdxChecksum{{"SHA256", lib.SHA256}},
+			Comment:   "copied from " + lib.RealPath + " in the builder image",
+		})
+	}
+	for _, file := range doc.Files {
+		doc.Relationships = append(doc.Relationships, spdxRelationship{function.SPDXID, "CONTAINS", file.SPDXID})
+	}
+	return doc
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagePurl(t *testing.T) {
+	assert.Equal(t, "pkg:docker/foo/hello@v1.0", imagePurl("foo/hello:v1.0", ""))
+	assert.Equal(t, "pkg:docker/localhost:5000/hello@latest", imagePurl("localhost:5000/hello", ""))
+	assert.Equal(t, "pkg:docker/foo/hello@sha256%3Aabcd", imagePurl("foo/hello:v1.0", "sha256:abcd"))
+}
+
+func TestNewSBOM(t *testing.T) {
+	src := &buildProvenance{
+		image:        "foo/hello:v1.0",
+		builderImage: defaultBuilderImage,
+		runtimeImage: defaultRuntimeImage,
+		gitCommit:    "0123456789abcdef",
+		gitDirty:     true,
+		makefile:     "./src/Makefile",
+		makeArgs:     "-j4",
+	}
+	report := &DependencyReport{
+		Executable:       "/app/src/mpi-func",
+		ExecutableSHA256: "e0",
+		Bundled:          []LibraryReport{{Name: "libfoo.so.1", RealPath: "/usr/lib/libfoo.so.1.2", SHA256: "f1"}},
+	}
+	doc := newSBOM(src, report)
+
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Equal(t, 3, len(doc.Packages))
+	assert.Equal(t, true, strings.Contains(doc.Packages[0].SourceInfo, "git commit 0123456789abcdef with uncommitted changes"))
+	assert.Equal(t, true, strings.Contains(doc.Packages[0].SourceInfo, "[-j4]"))
+	assert.Equal(t, defaultRuntimeImage, doc.Packages[1].Name)
+
+	// the executable and every bundled library are files of the function package
+	assert.Equal(t, 2, len(doc.Files))
+	assert.Equal(t, "/app/mpi-func", doc.Files[0].FileName)
+	assert.Equal(t, "/usr/local/lib/libfoo.so.1", doc.Files[1].FileName)
+	assert.Equal(t, "f1", doc.Files[1].Checksums[0].ChecksumValue)
+	contains := 0
+	for _, rel := range doc.Relationships {
+		if rel.RelationshipType == "CONTAINS" {
+			contains++
+		}
+	}
+	assert.Equal(t, 2, contains)
+
+	// the SBOM copied into the image is the same for the same sources and files
+	src.created = time.Now()
+	src.sourceDate = time.Date(2023, 10, 19, 8, 0, 0, 0, time.UTC)
+	again := newSBOM(src, report)
+	assert.Equal(t, doc.DocumentNamespace, again.DocumentNamespace)
+	assert.Equal(t, "2023-10-19T08:00:00Z", again.CreationInfo.Created)
+	report.ExecutableSHA256 = "e1"
+	assert.Equal(t, true, newSBOM(src, report).DocumentNamespace != doc.DocumentNamespace, "test failed: namespace unchanged by another executable")
+}
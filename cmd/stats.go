@@ -0,0 +1,234 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// The memory suggested for the cluster is the peak memory of the local run plus this headroom
+const statsMemoryHeadroom = 1.2
+
+// statsSample is the resource usage of a container at a time of the run
+type statsSample struct {
+	elapsed    time.Duration
+	container  string
+	memory     uint64
+	cpuPercent float64
+}
+
+// statsCollector samples the stats of the containers of a run until it is stopped
+type statsCollector struct {
+	mu      sync.Mutex
+	start   time.Time
+	samples []statsSample
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+}
+
+// collectStats starts sampling the stats of the containers, given by ID with their names
+func (dh *DockerHelper) collectStats(containers map[string]string) *statsCollector {
+	ctx, cancel := context.WithCancel(dh.ctx)
+	c := &statsCollector{start: time.Now(), cancel: cancel}
+	for containerID, name := range containers {
+		c.wg.Add(1)
+		go func(containerID string, name string) {
+			defer c.wg.Done()
+			// The stream ends when the container exits
+			stats, err := dh.cli.ContainerStats(ctx, containerID, true)
+			if err != nil {
+				return
+			}
+			defer stats.Body.Close()
+			decoder := json.NewDecoder(stats.Body)
+			for first := true; ; first = false {
+				var s types.StatsJSON
+				if err := decoder.Decode(&s); err != nil {
+					return
+				}
+				// The first stats have no previous CPU usage to compute the CPU usage from
+				if first {
+					continue
+				}
+				if memory, cpu, ok := usage(&s); ok {
+					c.add(statsSample{elapsed: time.Since(c.start), container: name, memory: memory, cpuPercent: cpu})
+				}
+			}
+		}(containerID, name)
+	}
+	return c
+}
+
+func (c *statsCollector) add(sample statsSample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, sample)
+}
+
+// stop stops sampling and returns the samples and the wall time of the run
+func (c *statsCollector) stop() ([]statsSample, time.Duration) {
+	wall := time.Since(c.start)
+	c.cancel()
+	c.wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.samples, wall
+}
+
+// usage returns the memory used by a container without the page cache, and its CPU usage in percent of one CPU,
+// as 'docker stats' computes them
+func usage(s *types.StatsJSON) (uint64, float64, bool) {
+	if s.Read.IsZero() || s.MemoryStats.Usage == 0 {
+		return 0, 0, false
+	}
+	memory := s.MemoryStats.Usage
+	// cgroup v1 and v2 name the page cache differently
+	for _, cache := range []string{"total_inactive_file", "inactive_file"} {
+		if v, ok := s.MemoryStats.Stats[cache]; ok && v < memory {
+			memory -= v
+			break
+		}
+	}
+	cpu := 0.0
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		cpu = cpuDelta / systemDelta * cpus * 100
+	}
+	return memory, cpu, true
+}
+
+// statsReport sums up the resource usage of a run
+type statsReport struct {
+	wall       time.Duration
+	peakMemory uint64
+	avgCPU     float64
+	// the suggested --mem-size of each memory allocation mode, in GB
+	perCoreSize int
+	totalSize   int
+}
+
+// newStatsReport computes the report of a run of np processes. The usage of the containers is added up every
+// second, as the containers of --nodes share the job. A container without a sample in a second counts with its
+// last sample.
+func newStatsReport(samples []statsSample, wall time.Duration, np int) statsReport {
+	sorted := append([]statsSample{}, samples...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].elapsed < sorted[j].elapsed })
+
+	report := statsReport{wall: wall}
+	current := map[string]statsSample{}
+	seconds := 0
+	for i := 0; i < len(sorted); {
+		second := sorted[i].elapsed / time.Second
+		for ; i < len(sorted) && sorted[i].elapsed/time.Second == second; i++ {
+			current[sorted[i].container] = sorted[i]
+		}
+		var memory uint64
+		var cpu float64
+		for _, s := range current {
+			memory += s.memory
+			cpu += s.cpuPercent
+		}
+		if memory > report.peakMemory {
+			report.peakMemory = memory
+		}
+		report.avgCPU += cpu
+		seconds++
+	}
+	if seconds > 0 {
+		report.avgCPU /= float64(seconds)
+	}
+	gb := float64(report.peakMemory) * statsMemoryHeadroom / (1 << 30)
+	report.totalSize = int(math.Max(1, math.Ceil(gb)))
+	report.perCoreSize = int(math.Max(1, math.Ceil(gb/float64(np))))
+	return report
+}
+
+func printStatsReport(w io.Writer, report statsReport, np int) error {
+	tw := tabwriter.NewWriter(w, 2, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Resource usage:")
+	fmt.Fprintf(tw, "  Wall time:\t%v\n", report.wall.Round(100*time.Millisecond))
+	if report.peakMemory == 0 {
+		fmt.Fprintln(tw, "  No stats collected, the run was too short")
+		return tw.Flush()
+	}
+	fmt.Fprintf(tw, "  Peak memory:\t%s (%s per process)\n", formatSize(int64(report.peakMemory)), formatSize(int64(report.peakMemory)/int64(np)))
+	fmt.Fprintf(tw, "  Average CPU:\t%.0f%% (%.2f CPUs for %d processes)\n", report.avgCPU, report.avgCPU/100, np)
+	fmt.Fprintf(tw, "  Suggested:\t--mem-mode %s --mem-size %d, or --mem-mode %s --mem-size %d\n",
+		FixedPerCoreMemory, report.perCoreSize, FixedTotalMemory, report.totalSize)
+	return tw.Flush()
+}
+
+// writeStatsCSV writes the samples as a time series, one line per container and sample
+func writeStatsCSV(name string, samples []statsSample) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sorted := append([]statsSample{}, samples...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].elapsed < sorted[j].elapsed })
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"seconds", "container", "memory_bytes", "cpu_percent"})
+	for _, s := range sorted {
+		w.Write([]string{
+			strconv.FormatFloat(s.elapsed.Seconds(), 'f', 1, 64),
+			s.container,
+			strconv.FormatUint(s.memory, 10),
+			strconv.FormatFloat(s.cpuPercent, 'f', 1, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// reportStats stops the collector and prints the resource usage of the run
+func (r *DockerRunOptions) reportStats(collector *statsCollector) error {
+	samples, wall := collector.stop()
+	fmt.Println()
+	if err := printStatsReport(os.Stdout, newStatsReport(samples, wall, r.parallel), r.parallel); err != nil {
+		return err
+	}
+	if r.statsCSV == "" {
+		return nil
+	}
+	if err := writeStatsCSV(r.statsCSV, samples); err != nil {
+		return err
+	}
+	fmt.Println("  Stats written to", r.statsCSV)
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2023 RHINO Team
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsReport(t *testing.T) {
+	s := &types.StatsJSON{}
+	s.Read = time.Now()
+	s.MemoryStats.Usage = 600 << 20
+	s.MemoryStats.Stats = map[string]uint64{"inactive_file": 88 << 20}
+	s.CPUStats.CPUUsage.TotalUsage = 3000
+	s.PreCPUStats.CPUUsage.TotalUsage = 1000
+	s.CPUStats.SystemUsage = 12000
+	s.PreCPUStats.SystemUsage = 4000
+	s.CPUStats.OnlineCPUs = 8
+	memory, cpu, ok := usage(s)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint64(512<<20), memory)
+	assert.Equal(t, 200.0, cpu)
+
+	// only the last sample of w0 taken between 1s and 2s counts, and w1 keeps its usage when it has no sample
+	samples := []statsSample{
+		{elapsed: 1100 * time.Millisecond, container: "w0", memory: 1 << 30, cpuPercent: 100},
+		{elapsed: 1200 * time.Millisecond, container: "w0", memory: 1 << 30, cpuPercent: 100},
+		{elapsed: 1500 * time.Millisecond, container: "w1", memory: 2 << 30, cpuPercent: 300},
+		{elapsed: 2200 * time.Millisecond, container: "w0", memory: 512 << 20, cpuPercent: 200},
+	}
+	report := newStatsReport(samples, 2500*time.Millisecond, 4)
+	assert.Equal(t, statsReport{wall: 2500 * time.Millisecond, peakMemory: 3 << 30, avgCPU: 450, perCoreSize: 1, totalSize: 4}, report)
+
+	// the peak counts the last sample of w1 taken in a previous second
+	samples[3].memory = 2 << 30
+	assert.Equal(t, uint64(4<<30), newStatsReport(samples, 2500*time.Millisecond, 4).peakMemory)
+	samples[3].memory = 512 << 20
+
+	var out strings.Builder
+	printStatsReport(&out, report, 4)
+	expected := "Resource usage:\n" +
+		"  Wall time:    2.5s\n" +
+		"  Peak memory:  3.0GiB (768.0MiB per process)\n" +
+		"  Average CPU:  450% (4.50 CPUs for 4 processes)\n" +
+		"  Suggested:    --mem-mode FixedPerCoreMemory --mem-size 1, or --mem-mode FixedTotalMemory --mem-size 4\n"
+	assert.Equal(t, expected, out.String())
+
+	csvFile := filepath.Join(t.TempDir(), "stats.csv")
+	err := writeStatsCSV(csvFile, samples[1:3])
+	assert.Equal(t, nil, err, errorMessage(err))
+	data, _ := os.ReadFile(csvFile)
+	assert.Equal(t, "seconds,container,memory_bytes,cpu_percent\n1.2,w0,1073741824,100.0\n1.5,w1,2147483648,300.0\n", string(data))
+}
@@ -18,10 +18,11 @@ import (
 )
 
 //go:generate go run main.go
-const templatesPath = "../../templates/func"
+const templatesPath = "../../templates"
 
 // This program generates zz_filesystem_generated.go file containing byte array variable named TemplatesZip.
-// The variable contains zip of "./templates/func" directory.
+// The variable contains zip of "./templates" directory: the function template in "func", and the files
+// replacing the Makefile for the other build systems, e.g. "cmake".
 func main() {
 	f, err := os.OpenFile("../../generate/zz_filesystem_generated.go", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -51,6 +52,10 @@ func main() {
 		if name == "." {
 			return nil
 		}
+		// Skip the build outputs left by running rhino in the template folder
+		if info.IsDir() && info.Name() == ".rhino" {
+			return filepath.SkipDir
+		}
 		name = filepath.ToSlash(name)
 		if info.IsDir() {
 			name = name + "/"